@@ -14,8 +14,10 @@
 package linewrap
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -23,16 +25,20 @@ const (
 	cr                    = '\r'
 	nl                    = '\n'
 	tab                   = '\t'
+	esc                   = '\x1b'
 	zeroWidthNoBreakSpace = "\uFEFF"
+	zeroWidthSpace        = "\u200B"
 )
 
-// Pos is a byte position in the original input text.
+// Pos is a byte position in the original input text. If the input was runes,
+// it is the index of the rune. Like the index of a slice, it is an int, so the
+// input can't be larger than the max int, e.g. 2GB on 32-bit platforms.
 type Pos int
 
 type token struct {
 	typ   tokenType
 	pos   Pos
-	len   int // kength in chars (not bytes)
+	len   int // width, in the lexer's unit; see lexOptions.measure
 	value string
 }
 
@@ -46,6 +52,21 @@ func (t token) String() string {
 	return t.value
 }
 
+// kind returns the TokenKind of the token.
+func (t token) kind() TokenKind {
+	switch {
+	case t.typ == tokenNL:
+		return NewlineToken
+	case t.typ == tokenWhitespace && strings.Trim(t.value, "\t") == "":
+		return TabToken
+	case isSpace(t.typ):
+		return SpaceToken
+	case isHyphen(t.typ):
+		return HyphenToken
+	}
+	return TextToken
+}
+
 func (t token) Error() string {
 	return fmt.Sprintf("lex error at %d: %s", int(t.pos), t.value)
 }
@@ -57,6 +78,8 @@ const (
 	tokenError
 	tokenEOF
 	tokenText                  // anything that isn't one of the following
+	tokenNarrowNoBreakSpace    // U+202F, a non-breaking space; no break occurs on either side of it
+	tokenWordJoiner            // U+2060, the replacement for U+FEFF as a joiner; no break occurs on either side of it
 	tokenZeroWidthNoBreakSpace // U+FEFF used for unwrappable
 	tokenNL                    // \n
 	tokenCR                    // \r
@@ -66,13 +89,20 @@ const (
 	// unicode tables.
 
 	// whitespace tokens from https://www.cs.tut.fi/~jkorpela/chars/spaces.html
+	// along with the rest of the breakable chars with the Unicode White_Space
+	// property.
 	//
 	// exceptions to the table:
 	//   no-break space            U+00A0 is not considered whitespace for line break purposes
+	//   mongolian vowel separator U+180E is not considered whitespace, unless configured otherwise
 	//   narrow no-break space     U+202F is not considered whitespace for line break purposes
 	//   zero width no-break space U+FEFF is not considered whitespace for line break purposes
+	tokenWhitespace              // a run of whitespace, which may be any mix of the following; this is what's emitted
 	tokenTab                     // \t
 	tokenSpace                   // U+0020
+	tokenLineTabulation          // U+000B
+	tokenFormFeed                // U+000C
+	tokenNextLine                // U+0085
 	tokenOghamSpaceMark          // U+1680
 	tokenMongolianVowelSeparator // U+180E
 
@@ -90,6 +120,8 @@ const (
 
 	tokenHairSpace               // U+200A
 	tokenZeroWidthSpace          // U+200B
+	tokenLineSeparator           // U+2028
+	tokenParagraphSeparator      // U+2029
 	tokenMediumMathematicalSpace // U+205F
 	tokenIdeographicSpace        // U+3000
 
@@ -132,14 +164,23 @@ const (
 	tokenSmallEmDash          // U+FE58
 	tokenSmallHyphenMinus     // U+FE63
 	tokenFullWidthHyphenMinus // U+FF0D
+
+	// text that ends with a char that a break can occur after, e.g. a slash
+	// in a path; these are only break points when configured.
+	tokenBreakAfter
 )
 
 var key = map[string]tokenType{
 	"\r":     tokenCR,
 	"\n":     tokenNL,
 	"\t":     tokenTab,
+	"\u202F": tokenNarrowNoBreakSpace,
+	"\u2060": tokenWordJoiner,
 	"\uFEFF": tokenZeroWidthNoBreakSpace,
 	"\u0020": tokenSpace,
+	"\u000B": tokenLineTabulation,
+	"\u000C": tokenFormFeed,
+	"\u0085": tokenNextLine,
 	"\u1680": tokenOghamSpaceMark,
 	"\u180E": tokenMongolianVowelSeparator,
 	"\u2000": tokenEnQuad,
@@ -154,6 +195,8 @@ var key = map[string]tokenType{
 	"\u2009": tokenThinSpace,
 	"\u200A": tokenHairSpace,
 	"\u200B": tokenZeroWidthSpace,
+	"\u2028": tokenLineSeparator,
+	"\u2029": tokenParagraphSeparator,
 	"\u205F": tokenMediumMathematicalSpace,
 	"\u3000": tokenIdeographicSpace,
 	"\u002D": tokenHyphenMinus,
@@ -181,11 +224,17 @@ var vals = map[tokenType]string{
 	tokenError:                             "error",
 	tokenEOF:                               "eof",
 	tokenText:                              "text",
+	tokenNarrowNoBreakSpace:                "narrow no break space",
+	tokenWordJoiner:                        "word joiner",
 	tokenZeroWidthNoBreakSpace:             "zero width no break space",
 	tokenNL:                                "nl",
 	tokenCR:                                "cr",
+	tokenWhitespace:                        "whitespace",
 	tokenTab:                               "tab",
 	tokenSpace:                             "space",
+	tokenLineTabulation:                    "line tabulation",
+	tokenFormFeed:                          "form feed",
+	tokenNextLine:                          "next line",
 	tokenOghamSpaceMark:                    "ogham space mark",
 	tokenMongolianVowelSeparator:           "mongolian vowel separator",
 	tokenEnQuad:                            "en quad",
@@ -200,6 +249,8 @@ var vals = map[tokenType]string{
 	tokenThinSpace:                         "thin space",
 	tokenHairSpace:                         "hair space",
 	tokenZeroWidthSpace:                    "width space",
+	tokenLineSeparator:                     "line separator",
+	tokenParagraphSeparator:                "paragraph separator",
 	tokenMediumMathematicalSpace:           "medium mathematical space",
 	tokenIdeographicSpace:                  "ideographic space",
 	tokenHyphenMinus:                       "hyphen minus",
@@ -220,6 +271,7 @@ var vals = map[tokenType]string{
 	tokenSmallEmDash:                       "small em dash",
 	tokenSmallHyphenMinus:                  "small hyphen minus",
 	tokenFullWidthHyphenMinus:              "full width hyphen minus",
+	tokenBreakAfter:                        "break after",
 }
 
 const eof = -1
@@ -228,46 +280,221 @@ const (
 	classText tokenClass = iota
 	classCR
 	classNL
-	classTab
 	classSpace
 	classHyphen
+	classSoftHyphen // only when only soft hyphens are break points
 )
 
 type tokenClass int
 
 type stateFn func(*lexer) stateFn
 
+// lexOptions are the lexer's configurable classification rules.
+type lexOptions struct {
+	tabSize            int                     // the width of a tab, in columns
+	runeWidth          func(rune) int          // the width of a rune, in columns; if nil, each rune is 1 column wide
+	mvsBreaks          bool                    // whether the mongolian vowel separator is whitespace
+	figureSpaceNoBreak bool                    // whether the figure space is not whitespace
+	enDashRangeNoBreak bool                    // whether an en dash used as a range indicator is not a break point
+	dashBreaks         map[rune]BreakDirection // where a line can be broken at each dash; a dash that isn't in it is broken after
+	softHyphenOnly     bool                    // whether soft hyphens are the only hyphens that are break points; they're emitted as tokenSoftHyphen
+	breakAfterSlash    bool                    // whether a slash, outside of a URL, is a break point; it's emitted as tokenBreakAfter
+	breakAfter         string                  // the chars, other than in a number, that are break points; they're emitted as tokenBreakAfter
+	asciiSpaceOnly     bool                    // whether the space, U+0020, and tab are the only whitespace that are break points
+	strictSpaces       bool                    // whether only the whitespace in key is whitespace; otherwise otherSpace is the fallback
+	otherSpace         func(rune) bool         // the fallback for whitespace that isn't in key; if nil, unicode.IsSpace
+	ignoreANSI         bool                    // whether ANSI escape sequences are zero width
+	unit               LengthUnit              // what measure counts
+	noBreakOpen        rune                    // the start of a no break region; 0 if there are none
+	noBreakClose       rune                    // the end of a no break region
+	joiner             rune                    // no break occurs on either side of it, as with the word joiner; 0 if there isn't one
+	placeholders       bool                    // whether fmt verbs and template actions are text that isn't broken
+}
+
 type lexer struct {
-	input   []byte     // the string being scanned
-	state   stateFn    // the next lexing function to enter
-	pos     Pos        // current position of this item
-	start   Pos        // start position of this item
-	width   Pos        // width of last rune read from input
-	lastPos Pos        // position of most recent item returned by nextItem
-	runeCnt int        // the number of runes in the current token sequence
-	tokens  chan token // channel of scanned tokens
+	input    []byte     // the string being scanned
+	runes    []rune     // the runes being scanned; used instead of input, if not nil
+	state    stateFn    // the next lexing function to enter
+	pos      Pos        // current position of this item
+	start    Pos        // start position of this item
+	width    Pos        // width of last rune read from input
+	lastPos  Pos        // position of most recent item returned by nextItem
+	tokens   chan token // channel of scanned tokens
+	noBreak  bool       // whether the lexer is in a no break region
+	running  bool       // whether run may still send tokens; only used by the receiver
+	backedUp bool       // whether backup was called since the last call to next
+	lexOptions
 }
 
 func lex(input []byte) *lexer {
+	return newLexer(input, nil, lexOptions{tabSize: TabSize})
+}
+
+// lexRunes returns a lexer that scans runes instead of bytes.
+func lexRunes(input []rune) *lexer {
+	return newLexer(nil, input, lexOptions{tabSize: TabSize})
+}
+
+// measure returns the width of s in o's unit. In columns, tabs are tabSize
+// columns wide.
+func (o lexOptions) measure(s string) int {
+	switch o.unit {
+	case Bytes:
+		return len(s)
+	case Runes:
+		return utf8.RuneCountInString(s)
+	}
+	var n int
+	for i := 0; i < len(s); {
+		if o.ignoreANSI && s[i] == esc {
+			if l := ansiLen(s[i:]); l > 0 {
+				i += l
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == tab:
+			n += o.tabSize
+		case o.runeWidth != nil:
+			n += o.runeWidth(r)
+		case isFormat(r):
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// isFormat returns whether r is an invisible format char, one in the Unicode
+// Cf category, e.g. the zero width non-joiner, U+200C, and the zero width
+// joiner, U+200D, which join and separate the chars of ligatures and emoji
+// sequences. Format chars are zero width. Those that aren't in key are text,
+// so no break occurs at them; those in key, e.g. the zero width space and the
+// word joiner, keep their own break handling. The soft hyphen isn't included:
+// it's shown as a hyphen when a line is broken at it.
+func isFormat(r rune) bool {
+	return r > '\u00AD' && unicode.Is(unicode.Cf, r)
+}
+
+// ansiLen returns the length, in bytes, of the ANSI control sequence, CSI,
+// that s starts with, e.g. "\x1b[31m"; if s doesn't start with one, 0 is
+// returned.
+func ansiLen(s string) int {
+	if len(s) < 3 || s[0] != esc || s[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 0x20 && c <= 0x3F: // parameter and intermediate bytes
+		case c >= 0x40 && c <= 0x7E: // the final byte
+			return i + 1
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// newLexer returns a lexer for either input or runes, using opts.
+func newLexer(input []byte, runes []rune, opts lexOptions) *lexer {
 	l := &lexer{
-		input:  input,
-		state:  lexText,
-		tokens: make(chan token, 2),
+		tokens:     make(chan token, 2),
+		lexOptions: opts,
 	}
-	go l.run()
+	l.scan(input, runes)
 	return l
 }
 
-// next returns the next rune in the input.
-func (l *lexer) next() rune {
-	l.runeCnt++
+// reset resets the lexer so that it scans input; the lexer, and its channel,
+// are reused. Any tokens from the prior input that haven't been received are
+// discarded.
+func (l *lexer) reset(input []byte) {
+	l.scan(input, nil)
+}
+
+// resetRunes resets the lexer so that it scans runes; see reset.
+func (l *lexer) resetRunes(input []rune) {
+	l.scan(nil, input)
+}
+
+// scan starts lexing either input or runes, once the lexing of any prior
+// input is done.
+func (l *lexer) scan(input []byte, runes []rune) {
+	l.drain()
+	l.input = input
+	l.runes = runes
+	l.state = lexText
+	l.pos = 0
+	l.start = 0
+	l.width = 0
+	l.backedUp = false
+	l.lastPos = 0
+	l.noBreak = false
+	l.running = true
+	go l.run()
+}
+
+// decode returns the rune at the current position in the input, along with
+// its width; at the end of the input, eof and 0 are returned.
+func (l *lexer) decode() (rune, Pos) {
+	if l.runes != nil {
+		if int(l.pos) >= len(l.runes) {
+			return eof, 0
+		}
+		return l.runes[l.pos], 1
+	}
 	if int(l.pos) >= len(l.input) {
-		l.width = 0
-		return eof
+		return eof, 0
 	}
 	r, w := utf8.DecodeRune(l.input[l.pos:])
-	l.width = Pos(w)
+	return r, Pos(w)
+}
+
+// line returns the line of the input that starts at pos, without its new
+// line.
+func (l *lexer) line(pos Pos) string {
+	var s string
+	if l.runes != nil {
+		end := int(pos)
+		for end < len(l.runes) && l.runes[end] != nl {
+			end++
+		}
+		if int(pos) < end {
+			s = string(l.runes[pos:end])
+		}
+	} else if int(pos) < len(l.input) {
+		s = string(l.input[pos:])
+		if i := strings.IndexByte(s, nl); i >= 0 {
+			s = s[:i]
+		}
+	}
+	return strings.TrimRight(s, "\r")
+}
+
+// text returns the input being scanned.
+func (l *lexer) text() string {
+	if l.runes != nil {
+		return string(l.runes)
+	}
+	return string(l.input)
+}
+
+// value returns the current token's value.
+func (l *lexer) value() string {
+	if l.runes != nil {
+		return string(l.runes[l.start:l.pos])
+	}
+	return string(l.input[l.start:l.pos])
+}
+
+// next returns the next rune in the input.
+func (l *lexer) next() rune {
+	r, w := l.decode()
+	l.width = w
 	l.pos += l.width
+	l.backedUp = false
 	return r
 }
 
@@ -278,23 +505,46 @@ func (l *lexer) peek() rune {
 	return r
 }
 
-// backup steps back one rune. Can be called only once per call of next.
+// backup steps back one rune. Can be called only once per call of next; a
+// second call would step back by the width of the wrong rune, so it panics
+// instead of corrupting pos.
 func (l *lexer) backup() {
+	if l.backedUp {
+		panic("linewrap: lexer backup called more than once per call to next")
+	}
 	l.pos -= l.width
-	l.runeCnt--
+	l.backedUp = true
 }
 
 // emit passes an item back to the client.
 func (l *lexer) emit(t tokenType) {
-	l.tokens <- token{t, l.start, l.runeCnt, string(l.input[l.start:l.pos])}
+	if t == tokenText && l.noBreakOpen != 0 {
+		l.emitText()
+		return
+	}
+	v := l.value()
+	tkn := token{t, l.start, l.measure(v), v}
+	l.start = l.pos // before the send, the receiver may reset the lexer after an EOF
+	l.tokens <- tkn
+}
+
+// emitText passes a text token back to the client with any no break region
+// delimiters elided.
+func (l *lexer) emitText() {
+	v := strings.Map(func(r rune) rune {
+		if r == l.noBreakOpen || r == l.noBreakClose {
+			return -1
+		}
+		return r
+	}, l.value())
+	tkn := token{tokenText, l.start, l.measure(v), v}
 	l.start = l.pos
-	l.runeCnt = 0
+	l.tokens <- tkn
 }
 
 // ignore skips over the pending input before this point.
 func (l *lexer) ignore() {
 	l.start = l.pos
-	l.runeCnt = 0
 }
 
 // accept consumes the next rune if it's from the valid set.
@@ -324,12 +574,16 @@ func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 func (l *lexer) nextToken() token {
 	token := <-l.tokens
 	l.lastPos = token.pos
+	if token.typ == tokenEOF || token.typ == tokenError {
+		l.running = false
+	}
 	return token
 }
 
 // drain the channel so the lex go routine will exit: called by caller.
 func (l *lexer) drain() {
-	for range l.tokens {
+	for l.running {
+		l.nextToken()
 	}
 }
 
@@ -338,13 +592,70 @@ func (l *lexer) run() {
 	for state := lexText; state != nil; {
 		state = state(l)
 	}
-	close(l.tokens) // No more tokens will be delivered
+	// the channel isn't closed so that it can be reused; the EOF, or error,
+	// token is the last token delivered.
 }
 
 // lexText scans non whitespace/hyphen chars.
 func lexText(l *lexer) stateFn {
 	for {
+		if l.noBreakOpen != 0 {
+			l.checkNoBreak()
+		}
+		// a placeholder is text; no break occurs within it.
+		if l.placeholders {
+			if n := l.placeholderLen(); n > 0 {
+				l.pos += Pos(n)
+				continue
+			}
+		}
 		is, class := l.atBreakPoint() // a breakpoint is any char after which a new line can be
+		// within a no break region, only new lines are breakpoints.
+		if is && l.noBreak && class != classNL && class != classCR {
+			is = false
+		}
+		if is && class == classHyphen && l.enDashRangeNoBreak && l.atRange() {
+			is = false
+		}
+		if is && class != classNL && class != classCR && l.isJoined() {
+			is = false
+		}
+		if is && class == classHyphen && l.dashBreaks != nil {
+			r, _ := l.decode()
+			switch l.dashBreaks[r] {
+			case DashNoBreak:
+				is = false
+			case DashBreakBefore:
+				// the text before the dash ends with a break point; the dash
+				// starts the text after it.
+				if l.pos > l.start {
+					l.emit(tokenBreakAfter)
+				}
+				l.next()
+				continue
+			}
+		}
+		// a narrow no-break space is its own token; it isn't a breakpoint.
+		if !is && !l.noBreak && l.atNarrowNoBreakSpace() {
+			if l.pos > l.start {
+				l.emit(tokenText)
+			}
+			l.next()
+			l.emit(tokenNarrowNoBreakSpace)
+			continue
+		}
+		// a slash in a path ends the text token; a break can occur after it.
+		if !is && !l.noBreak && l.breakAfterSlash && l.atPathSlash() {
+			l.next()
+			l.emit(tokenBreakAfter)
+			continue
+		}
+		// so does a configured break after char that isn't in a number.
+		if !is && !l.noBreak && l.breakAfter != "" && l.atBreakAfter() {
+			l.next()
+			l.emit(tokenBreakAfter)
+			continue
+		}
 		if is {
 			if l.pos > l.start {
 				l.emit(tokenText)
@@ -356,14 +667,13 @@ func lexText(l *lexer) stateFn {
 				return lexNL
 			case classSpace:
 				return lexSpace
-			case classTab:
-				return lexTab
 			case classHyphen:
 				return lexHyphen
+			case classSoftHyphen:
+				return lexSoftHyphen
 			}
 		}
 		if l.next() == eof {
-			l.runeCnt-- // eof doesn't count.
 			break
 		}
 	}
@@ -375,12 +685,115 @@ func lexText(l *lexer) stateFn {
 	return nil       // Stop the run loop.
 }
 
+// placeholderLen returns the length, in the lexer's position units, of the
+// placeholder that starts at the current char; 0 if one doesn't start there.
+// See placeholderLen.
+func (l *lexer) placeholderLen() int {
+	r, _ := l.decode()
+	if r != '%' && r != '{' {
+		return 0
+	}
+	if l.runes != nil {
+		rest := l.runes[l.pos:]
+		for i, r := range rest {
+			if r == nl {
+				rest = rest[:i]
+				break
+			}
+		}
+		s := string(rest)
+		return utf8.RuneCountInString(s[:placeholderLen(s)])
+	}
+	rest := l.input[l.pos:]
+	if i := bytes.IndexByte(rest, nl); i >= 0 {
+		rest = rest[:i]
+	}
+	return placeholderLen(string(rest))
+}
+
+// placeholderLen returns the length, in bytes, of the placeholder that s
+// starts with: either a fmt verb, e.g. "%-10.2f", "%[1]*d", or "%%", or a
+// template action, e.g. "{{.Name}}" or "{{ .Name | printf "%s" }}"; 0 if s
+// doesn't start with one. A verb's flags don't include the space, so that
+// the "% o" in "50% off" isn't a verb.
+func placeholderLen(s string) int {
+	if strings.HasPrefix(s, "{{") {
+		if i := strings.Index(s[2:], "}}"); i >= 0 {
+			return i + 4
+		}
+		return 0
+	}
+	if !strings.HasPrefix(s, "%") {
+		return 0
+	}
+	i := 1
+	for i < len(s) && strings.IndexByte("+-#0", s[i]) >= 0 {
+		i++
+	}
+	i = skipArgIndex(s, i)
+	i = skipWidth(s, i)
+	if i < len(s) && s[i] == '.' {
+		i = skipWidth(s, i+1)
+	}
+	i = skipArgIndex(s, i)
+	if i < len(s) && (s[i] == '%' || 'a' <= s[i] && s[i] <= 'z' || 'A' <= s[i] && s[i] <= 'Z') {
+		return i + 1
+	}
+	return 0
+}
+
+// skipArgIndex returns the index in s after the explicit argument index, e.g.
+// "[1]", that starts at i, if there is one; otherwise i.
+func skipArgIndex(s string, i int) int {
+	if i >= len(s) || s[i] != '[' {
+		return i
+	}
+	j := i + 1
+	for j < len(s) && '0' <= s[j] && s[j] <= '9' {
+		j++
+	}
+	if j == i+1 || j >= len(s) || s[j] != ']' {
+		return i
+	}
+	return j + 1
+}
+
+// skipWidth returns the index in s after the width or precision, either
+// digits or a '*', that starts at i; if there isn't one, i.
+func skipWidth(s string, i int) int {
+	if i < len(s) && s[i] == '*' {
+		return i + 1
+	}
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+// checkNoBreak checks whether the current char starts or ends a no break
+// region.
+func (l *lexer) checkNoBreak() {
+	r, _ := l.decode()
+	switch {
+	case l.noBreak && r == l.noBreakClose:
+		l.noBreak = false
+	case !l.noBreak && r == l.noBreakOpen:
+		l.noBreak = true
+	}
+}
+
 // a breakpoint is any character afterwhich a wrap may occur. If it is a
 // breakpoint char, the type of char is returned.
 func (l *lexer) atBreakPoint() (breakpoint bool, class tokenClass) {
-	r, _ := utf8.DecodeRune(l.input[l.pos:])
+	r, _ := l.decode()
 	t, ok := key[string(r)]
-	if !ok || t <= tokenZeroWidthNoBreakSpace {
+	if !ok {
+		if l.isOtherSpace(r) {
+			return true, classSpace
+		}
+		return false, classText
+	}
+	if t <= tokenZeroWidthNoBreakSpace {
 		return false, classText
 	}
 	switch t {
@@ -388,12 +801,16 @@ func (l *lexer) atBreakPoint() (breakpoint bool, class tokenClass) {
 		return true, classCR
 	case tokenNL:
 		return true, classNL
-	case tokenTab:
-		return true, classTab
 	}
-	if isSpace(t) {
+	if l.isSpace(t) {
 		return true, classSpace
 	}
+	if l.softHyphenOnly {
+		if t == tokenSoftHyphen {
+			return true, classSoftHyphen
+		}
+		return false, classText
+	}
 	if isHyphen(t) {
 		return true, classHyphen
 	}
@@ -401,6 +818,130 @@ func (l *lexer) atBreakPoint() (breakpoint bool, class tokenClass) {
 	return false, classText
 }
 
+// isJoined returns whether the current char is a joiner or is next to one;
+// no break occurs on either side of a joiner.
+func (l *lexer) isJoined() bool {
+	r, w := l.decode()
+	if l.isJoiner(r) || l.isJoiner(l.prev()) {
+		return true
+	}
+	l.pos += w
+	next, _ := l.decode()
+	l.pos -= w
+	return l.isJoiner(next)
+}
+
+// isJoiner returns whether r joins the text on either side of it: it is either
+// the word joiner, U+2060, or the configured joiner.
+func (l *lexer) isJoiner(r rune) bool {
+	return r == '\u2060' || (r == l.joiner && r != 0)
+}
+
+// prev returns the char before the current one; eof if there isn't one.
+func (l *lexer) prev() rune {
+	if l.pos == 0 {
+		return eof
+	}
+	if l.runes != nil {
+		return l.runes[l.pos-1]
+	}
+	r, _ := utf8.DecodeLastRune(l.input[:l.pos])
+	return r
+}
+
+// atNarrowNoBreakSpace returns whether the current char is a narrow no-break
+// space, U+202F.
+func (l *lexer) atNarrowNoBreakSpace() bool {
+	r, _ := l.decode()
+	return r == '\u202F'
+}
+
+// atPathSlash returns whether the current char is a slash that isn't in a
+// URL, e.g. the slashes in "/usr/local/share". A slash between two digits,
+// e.g. in the fraction "1/2", isn't one.
+func (l *lexer) atPathSlash() bool {
+	r, _ := l.decode()
+	return r == '/' && !l.betweenDigits() && !isURL(l.word())
+}
+
+// atBreakAfter returns whether the current char is one of the configured
+// chars that a break can occur after. A char between two digits, e.g. the ','
+// in "1,000" or the '.' in "3.14", is part of a number, so it isn't.
+func (l *lexer) atBreakAfter() bool {
+	r, _ := l.decode()
+	if r == eof || !strings.ContainsRune(l.breakAfter, r) {
+		return false
+	}
+	return !l.betweenDigits()
+}
+
+// betweenDigits returns whether the current char is between two digits, e.g.
+// the ',' in "1,000" or the '/' in "1/2".
+func (l *lexer) betweenDigits() bool {
+	if !unicode.IsDigit(l.prev()) {
+		return false
+	}
+	_, w := l.decode()
+	l.pos += w
+	next, _ := l.decode()
+	l.pos -= w
+	return unicode.IsDigit(next)
+}
+
+// word returns the whitespace delimited word that the current char is in.
+func (l *lexer) word() string {
+	if l.runes != nil {
+		start, end := int(l.pos), int(l.pos)
+		for start > 0 && !unicode.IsSpace(l.runes[start-1]) {
+			start--
+		}
+		for end < len(l.runes) && !unicode.IsSpace(l.runes[end]) {
+			end++
+		}
+		return string(l.runes[start:end])
+	}
+	start, end := int(l.pos), int(l.pos)
+	for start > 0 {
+		r, w := utf8.DecodeLastRune(l.input[:start])
+		if unicode.IsSpace(r) {
+			break
+		}
+		start -= w
+	}
+	for end < len(l.input) {
+		r, w := utf8.DecodeRune(l.input[end:])
+		if unicode.IsSpace(r) {
+			break
+		}
+		end += w
+	}
+	return string(l.input[start:end])
+}
+
+// isURL returns whether s looks like a URL: it has a scheme, e.g.
+// "https://", or it starts with "www.".
+func isURL(s string) bool {
+	s = strings.TrimLeft(s, "(<[\"'")
+	return strings.Contains(s, "://") || strings.HasPrefix(s, "www.")
+}
+
+// atRange returns whether the current char is an en dash that is used as a
+// range indicator, e.g. "10–20": it is both preceded and followed by text.
+func (l *lexer) atRange() bool {
+	r, w := l.decode()
+	if r != '\u2013' || l.pos == l.start { // a token was just emitted, so there's no text before it
+		return false
+	}
+	l.pos += w
+	next, _ := l.decode()
+	l.pos -= w
+	if next == eof {
+		return false
+	}
+	t, ok := key[string(next)]
+	return !ok || t <= tokenZeroWidthNoBreakSpace
+}
+
 // lexCR handles a carriage return, `\r`; these are skipped. The prior token
 // should already have been emitted and the next token should be a CR, which
 // are skipped.  The next token is checked to ensure that it really is a CR.
@@ -425,29 +966,18 @@ func lexNL(l *lexer) stateFn {
 	return lexText
 }
 
-// lexTab handles a tab, '\t'; the prior token should already have been emitted
-// and the next token should be a tab. The next token is checked to ensure that
-// it really is a tab.
-func lexTab(l *lexer) stateFn {
-	r := l.next()
-	t := key[string(r)] // don't need to check ok, as the zero value won't match
-	if t == tokenTab {
-		l.emit(tokenTab)
-	}
-	return lexText
-}
-
-// This scans until end of the space sequence is encountered. If no spaces were
-// found, nothing will be emitted. The prior token should already have been
+// This scans until end of the whitespace sequence is encountered; the sequence
+// may be any mix of whitespace chars, e.g. spaces and tabs, and is emitted as a
+// single token whose len is the width of the whole sequence. If no whitespace
+// was found, nothing will be emitted. The prior token should already have been
 // emitted before this function gets called.
 func lexSpace(l *lexer) stateFn {
 	var i int
 	// scan until the spaces are consumed
 	for {
 		r := l.next()
-		// ok doesn't need to be checked as the zeroo value won't be classified as a hyphen.
-		tkn := key[string(r)]
-		if !isSpace(tkn) {
+		tkn, ok := key[string(r)]
+		if ok && !l.isSpace(tkn) || !ok && !l.isOtherSpace(r) {
 			break
 		}
 		i++
@@ -457,7 +987,7 @@ func lexSpace(l *lexer) stateFn {
 	}
 	// otherwise backup to ensure only space tokens are emitted.
 	l.backup()
-	l.emit(tokenSpace)
+	l.emit(tokenWhitespace)
 	return lexText
 }
 
@@ -484,8 +1014,45 @@ func lexHyphen(l *lexer) stateFn {
 	return lexText
 }
 
+// lexSoftHyphen emits a soft hyphen as its own token; it's only used when
+// soft hyphens are the only hyphens that are break points. The prior token
+// should already have been emitted before this function gets called.
+func lexSoftHyphen(l *lexer) stateFn {
+	l.next()
+	l.emit(tokenSoftHyphen)
+	return lexText
+}
+
+// isSpace returns whether t is a whitespace token, per the lexer's options.
+func (l *lexer) isSpace(t tokenType) bool {
+	if l.asciiSpaceOnly {
+		return t == tokenSpace || t == tokenTab
+	}
+	switch t {
+	case tokenMongolianVowelSeparator:
+		return l.mvsBreaks
+	case tokenFigureSpace:
+		return !l.figureSpaceNoBreak
+	}
+	return isSpace(t)
+}
+
+// isOtherSpace returns whether r, which isn't in key, is whitespace: Unicode
+// classifies it as whitespace and it isn't a no-break space. This handles
+// whitespace that is new to Unicode, unless only the whitespace in key is
+// whitespace.
+func (l *lexer) isOtherSpace(r rune) bool {
+	if l.strictSpaces || l.asciiSpaceOnly || r == '\u00A0' {
+		return false
+	}
+	if l.otherSpace != nil {
+		return l.otherSpace(r)
+	}
+	return unicode.IsSpace(r)
+}
+
 func isSpace(t tokenType) bool {
-	if t >= tokenTab && t <= tokenIdeographicSpace {
+	if t >= tokenWhitespace && t <= tokenIdeographicSpace {
 		return true
 	}
 	return false
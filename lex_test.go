@@ -13,7 +13,10 @@
 
 package linewrap
 
-import "testing"
+import (
+	"testing"
+	"unicode"
+)
 
 type lexTest struct {
 	input  string
@@ -24,68 +27,80 @@ type lexTest struct {
 
 var lexTests = []lexTest{
 	{"", []token{token{tokenEOF, 0, 0, ""}}},
-	{"hello world", []token{{tokenText, 0, 5, "hello"}, {tokenSpace, 5, 1, " "}, {tokenText, 6, 5, "world"}, token{tokenEOF, 11, 0, ""}}},
+	{"hello world", []token{{tokenText, 0, 5, "hello"}, {tokenWhitespace, 5, 1, " "}, {tokenText, 6, 5, "world"}, token{tokenEOF, 11, 0, ""}}},
 	{"Time is an illusion. Lunchtime doubly so.",
 		[]token{
-			{tokenText, 0, 4, "Time"}, {tokenSpace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenSpace, 7, 1, " "},
-			{tokenText, 8, 2, "an"}, {tokenSpace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenSpace, 20, 1, " "},
-			{tokenText, 21, 9, "Lunchtime"}, {tokenSpace, 30, 1, " "}, {tokenText, 31, 6, "doubly"}, {tokenSpace, 37, 1, " "},
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+			{tokenText, 8, 2, "an"}, {tokenWhitespace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenWhitespace, 20, 1, " "},
+			{tokenText, 21, 9, "Lunchtime"}, {tokenWhitespace, 30, 1, " "}, {tokenText, 31, 6, "doubly"}, {tokenWhitespace, 37, 1, " "},
 			{tokenText, 38, 3, "so."}, token{tokenEOF, 41, 0, ""},
 		},
 	},
 	{"Time is an illusion.\u2001Lunchtime doubly so.",
 		[]token{
-			{tokenText, 0, 4, "Time"}, {tokenSpace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenSpace, 7, 1, " "},
-			{tokenText, 8, 2, "an"}, {tokenSpace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenSpace, 20, 1, "\u2001"},
-			{tokenText, 23, 9, "Lunchtime"}, {tokenSpace, 32, 1, " "}, {tokenText, 33, 6, "doubly"}, {tokenSpace, 39, 1, " "},
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+			{tokenText, 8, 2, "an"}, {tokenWhitespace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenWhitespace, 20, 1, "\u2001"},
+			{tokenText, 23, 9, "Lunchtime"}, {tokenWhitespace, 32, 1, " "}, {tokenText, 33, 6, "doubly"}, {tokenWhitespace, 39, 1, " "},
 			{tokenText, 40, 3, "so."}, token{tokenEOF, 43, 0, ""},
 		},
 	},
 	{"Time is an illusion.\u2014Lunchtime doubly so.",
 		[]token{
-			{tokenText, 0, 4, "Time"}, {tokenSpace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenSpace, 7, 1, " "},
-			{tokenText, 8, 2, "an"}, {tokenSpace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenHyphen, 20, 1, "\u2014"},
-			{tokenText, 23, 9, "Lunchtime"}, {tokenSpace, 32, 1, " "}, {tokenText, 33, 6, "doubly"}, {tokenSpace, 39, 1, " "},
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+			{tokenText, 8, 2, "an"}, {tokenWhitespace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenHyphen, 20, 1, "\u2014"},
+			{tokenText, 23, 9, "Lunchtime"}, {tokenWhitespace, 32, 1, " "}, {tokenText, 33, 6, "doubly"}, {tokenWhitespace, 39, 1, " "},
 			{tokenText, 40, 3, "so."}, token{tokenEOF, 43, 0, ""},
 		},
 	},
 	{"Time is an illusion.-Lunchtime doubly so.",
 		[]token{
-			{tokenText, 0, 4, "Time"}, {tokenSpace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenSpace, 7, 1, " "},
-			{tokenText, 8, 2, "an"}, {tokenSpace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenHyphen, 20, 1, "-"},
-			{tokenText, 21, 9, "Lunchtime"}, {tokenSpace, 30, 1, " "}, {tokenText, 31, 6, "doubly"}, {tokenSpace, 37, 1, " "},
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+			{tokenText, 8, 2, "an"}, {tokenWhitespace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenHyphen, 20, 1, "-"},
+			{tokenText, 21, 9, "Lunchtime"}, {tokenWhitespace, 30, 1, " "}, {tokenText, 31, 6, "doubly"}, {tokenWhitespace, 37, 1, " "},
 			{tokenText, 38, 3, "so."}, token{tokenEOF, 41, 0, ""},
 		},
 	},
 	{"Time is an illusion.\tLunchtime doubly so.",
 		[]token{
-			{tokenText, 0, 4, "Time"}, {tokenSpace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenSpace, 7, 1, " "},
-			{tokenText, 8, 2, "an"}, {tokenSpace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenTab, 20, 1, "\t"},
-			{tokenText, 21, 9, "Lunchtime"}, {tokenSpace, 30, 1, " "}, {tokenText, 31, 6, "doubly"}, {tokenSpace, 37, 1, " "},
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+			{tokenText, 8, 2, "an"}, {tokenWhitespace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenWhitespace, 20, 8, "\t"},
+			{tokenText, 21, 9, "Lunchtime"}, {tokenWhitespace, 30, 1, " "}, {tokenText, 31, 6, "doubly"}, {tokenWhitespace, 37, 1, " "},
 			{tokenText, 38, 3, "so."}, token{tokenEOF, 41, 0, ""},
 		},
 	},
 	{"Time is an illusion.\nLunchtime doubly so.",
 		[]token{
-			{tokenText, 0, 4, "Time"}, {tokenSpace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenSpace, 7, 1, " "},
-			{tokenText, 8, 2, "an"}, {tokenSpace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenNL, 20, 1, "\n"},
-			{tokenText, 21, 9, "Lunchtime"}, {tokenSpace, 30, 1, " "}, {tokenText, 31, 6, "doubly"}, {tokenSpace, 37, 1, " "},
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+			{tokenText, 8, 2, "an"}, {tokenWhitespace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenNL, 20, 1, "\n"},
+			{tokenText, 21, 9, "Lunchtime"}, {tokenWhitespace, 30, 1, " "}, {tokenText, 31, 6, "doubly"}, {tokenWhitespace, 37, 1, " "},
 			{tokenText, 38, 3, "so."}, token{tokenEOF, 41, 0, ""},
 		},
 	},
 	{"Time is an illusion.\r\nLunchtime doubly so.",
 		[]token{
-			{tokenText, 0, 4, "Time"}, {tokenSpace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenSpace, 7, 1, " "},
-			{tokenText, 8, 2, "an"}, {tokenSpace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenNL, 21, 1, "\n"},
-			{tokenText, 22, 9, "Lunchtime"}, {tokenSpace, 31, 1, " "}, {tokenText, 32, 6, "doubly"}, {tokenSpace, 38, 1, " "},
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+			{tokenText, 8, 2, "an"}, {tokenWhitespace, 10, 1, " "}, {tokenText, 11, 9, "illusion."}, {tokenNL, 21, 1, "\n"},
+			{tokenText, 22, 9, "Lunchtime"}, {tokenWhitespace, 31, 1, " "}, {tokenText, 32, 6, "doubly"}, {tokenWhitespace, 38, 1, " "},
 			{tokenText, 39, 3, "so."}, token{tokenEOF, 42, 0, ""},
 		},
 	},
 	{"This sentence is a \nmeaningless one.",
 		[]token{
-			{tokenText, 0, 4, "This"}, {tokenSpace, 4, 1, " "}, {tokenText, 5, 8, "sentence"}, {tokenSpace, 13, 1, " "},
-			{tokenText, 14, 2, "is"}, {tokenSpace, 16, 1, " "}, {tokenText, 17, 1, "a"}, {tokenSpace, 18, 1, " "},
-			{tokenNL, 19, 1, "\n"}, {tokenText, 20, 11, "meaningless"}, {tokenSpace, 31, 1, " "}, {tokenText, 32, 4, "one."}, token{tokenEOF, 36, 0, ""},
+			{tokenText, 0, 4, "This"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 8, "sentence"}, {tokenWhitespace, 13, 1, " "},
+			{tokenText, 14, 2, "is"}, {tokenWhitespace, 16, 1, " "}, {tokenText, 17, 1, "a"}, {tokenWhitespace, 18, 1, " "},
+			{tokenNL, 19, 1, "\n"}, {tokenText, 20, 11, "meaningless"}, {tokenWhitespace, 31, 1, " "}, {tokenText, 32, 4, "one."}, token{tokenEOF, 36, 0, ""},
+		},
+	},
+	{"Time is \t an\t\u2003illusion.",
+		[]token{
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 10, " \t "},
+			{tokenText, 10, 2, "an"}, {tokenWhitespace, 12, 9, "\t\u2003"}, {tokenText, 16, 9, "illusion."}, token{tokenEOF, 25, 0, ""},
+		},
+	},
+	{"Time is an\u180Eillusion.",
+		[]token{
+			{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+			{tokenText, 8, 11, "an\u180Eillusion."}, token{tokenEOF, 22, 0, ""},
 		},
 	},
 }
@@ -190,3 +205,322 @@ func TestIsHyphen(t *testing.T) {
 		}
 	}
 }
+
+func TestIsSpace(t *testing.T) {
+	tests := []struct {
+		r rune
+		b bool
+	}{
+		{'\u0009', true},
+		{'\u000b', true},
+		{'\u000c', true},
+		{'\u0020', true},
+		{'\u0085', true},
+
+		{'\u00a0', false},
+		{'\u1680', true},
+		{'\u180e', false},
+		{'\u2000', true},
+		{'\u200a', true},
+
+		{'\u200b', true},
+		{'\u2028', true},
+		{'\u2029', true},
+		{'\u202f', false},
+		{'\u205f', true},
+		{'\u2060', false},
+
+		{'\u3000', true},
+		{'\ufeff', false},
+	}
+	l := &lexer{} // the default options
+	for _, test := range tests {
+		b := l.isSpace(key[string(test.r)])
+		if b != test.b {
+			t.Errorf("%x: got %t; want %t", test.r, b, test.b)
+		}
+	}
+	// every char with the White_Space property, other than the new line
+	// chars, is either whitespace or a known non-breaking space.
+	for _, rng := range unicode.White_Space.R16 {
+		for r := rune(rng.Lo); r <= rune(rng.Hi); r += rune(rng.Stride) {
+			typ, ok := key[string(r)]
+			switch {
+			case r == '\n' || r == '\r':
+			case r == '\u00a0':
+			case ok && (l.isSpace(typ) || typ == tokenNarrowNoBreakSpace || typ == tokenMongolianVowelSeparator):
+			default:
+				t.Errorf("%x: White_Space char isn't classified", r)
+			}
+		}
+	}
+}
+
+func TestLexNarrowNoBreakSpace(t *testing.T) {
+	l := lex([]byte("1\u202F000 km"))
+	expected := []token{
+		{tokenText, 0, 1, "1"}, {tokenNarrowNoBreakSpace, 1, 1, "\u202F"}, {tokenText, 4, 3, "000"},
+		{tokenWhitespace, 7, 1, " "}, {tokenText, 8, 2, "km"}, {tokenEOF, 10, 0, ""},
+	}
+	for i, want := range expected {
+		tkn := l.nextToken()
+		if tkn != want {
+			t.Errorf("%d: got %v want %v", i, tkn, want)
+		}
+	}
+}
+
+func TestLexBreakAfter(t *testing.T) {
+	expected := []token{
+		{tokenBreakAfter, 0, 4, "etc/"}, {tokenText, 4, 5, "hosts"}, {tokenWhitespace, 9, 1, " "},
+		{tokenText, 10, 16, "www.example.com/"}, {tokenWhitespace, 26, 1, " "},
+		{tokenText, 27, 14, "(http://a/b)/c"}, {tokenEOF, 41, 0, ""},
+	}
+	l := newLexer([]byte("etc/hosts www.example.com/ (http://a/b)/c"), nil, lexOptions{breakAfterSlash: true})
+	var tokens []token
+	for {
+		token := l.nextToken()
+		tokens = append(tokens, token)
+		if token.typ == tokenEOF || token.typ == tokenError {
+			break
+		}
+	}
+	equal(t, 0, tokens, expected)
+}
+
+func TestLexBreakAfterNumber(t *testing.T) {
+	expected := []token{
+		{tokenText, 0, 9, "1,000,000"}, {tokenWhitespace, 9, 1, " "}, {tokenText, 10, 4, "3.14"}, {tokenWhitespace, 14, 1, " "},
+		{tokenBreakAfter, 15, 2, "a,"}, {tokenBreakAfter, 17, 2, "1."}, {tokenText, 19, 1, "b"}, {tokenEOF, 20, 0, ""},
+	}
+	l := newLexer([]byte("1,000,000 3.14 a,1.b"), nil, lexOptions{breakAfter: ",."})
+	var tokens []token
+	for {
+		token := l.nextToken()
+		tokens = append(tokens, token)
+		if token.typ == tokenEOF || token.typ == tokenError {
+			break
+		}
+	}
+	equal(t, 0, tokens, expected)
+}
+
+func TestLexOtherSpace(t *testing.T) {
+	// U+FFF0 is unassigned; it stands in for whitespace that is new to
+	// Unicode, which wouldn't be in key.
+	newSpace := func(r rune) bool { return r == '\uFFF0' || unicode.IsSpace(r) }
+	tests := []struct {
+		input    string
+		strict   bool
+		expected []token
+	}{
+		{"a\uFFF0b", false, []token{{tokenText, 0, 1, "a"}, {tokenWhitespace, 1, 1, "\uFFF0"}, {tokenText, 4, 1, "b"}, {tokenEOF, 5, 0, ""}}},
+		{"a\uFFF0b", true, []token{{tokenText, 0, 3, "a\uFFF0b"}, {tokenEOF, 5, 0, ""}}},
+		// the no-break space isn't a break point
+		{"a\u00A0b", false, []token{{tokenText, 0, 3, "a\u00A0b"}, {tokenEOF, 4, 0, ""}}},
+	}
+	for i, test := range tests {
+		l := newLexer([]byte(test.input), nil, lexOptions{strictSpaces: test.strict, otherSpace: newSpace})
+		var tokens []token
+		for {
+			token := l.nextToken()
+			tokens = append(tokens, token)
+			if token.typ == tokenEOF || token.typ == tokenError {
+				break
+			}
+		}
+		equal(t, i, tokens, test.expected)
+	}
+}
+
+func TestLexBreakAfterFraction(t *testing.T) {
+	expected := []token{
+		{tokenText, 0, 3, "1/2"}, {tokenWhitespace, 3, 1, " "}, {tokenBreakAfter, 4, 4, "and/"}, {tokenText, 8, 2, "or"},
+		{tokenWhitespace, 10, 1, " "}, {tokenText, 11, 10, "10/31/2024"}, {tokenWhitespace, 21, 1, " "},
+		{tokenBreakAfter, 22, 2, "a/"}, {tokenText, 24, 1, "1"}, {tokenEOF, 25, 0, ""},
+	}
+	l := newLexer([]byte("1/2 and/or 10/31/2024 a/1"), nil, lexOptions{breakAfterSlash: true})
+	var tokens []token
+	for {
+		token := l.nextToken()
+		tokens = append(tokens, token)
+		if token.typ == tokenEOF || token.typ == tokenError {
+			break
+		}
+	}
+	equal(t, 0, tokens, expected)
+}
+
+func TestLexDashBreaks(t *testing.T) {
+	expected := []token{
+		{tokenBreakAfter, 0, 3, "abc"}, {tokenText, 3, 4, "\u058Adef"}, {tokenWhitespace, 8, 1, " "},
+		{tokenText, 9, 5, "x-y-z"}, {tokenWhitespace, 14, 1, " "},
+		{tokenText, 15, 1, "a"}, {tokenHyphen, 16, 1, "\u2014"}, {tokenText, 19, 1, "b"}, {tokenEOF, 20, 0, ""},
+	}
+	l := newLexer([]byte("abc\u058Adef x-y-z a\u2014b"), nil, lexOptions{dashBreaks: map[rune]BreakDirection{'\u058A': DashBreakBefore, '-': DashNoBreak}})
+	var tokens []token
+	for {
+		token := l.nextToken()
+		tokens = append(tokens, token)
+		if token.typ == tokenEOF || token.typ == tokenError {
+			break
+		}
+	}
+	equal(t, 0, tokens, expected)
+}
+
+func TestLexNoBreakDelimiters(t *testing.T) {
+	expected := []token{
+		{tokenText, 0, 4, "Time"}, {tokenWhitespace, 4, 1, " "}, {tokenText, 5, 2, "is"}, {tokenWhitespace, 7, 1, " "},
+		{tokenText, 8, 12, "an illusion."}, {tokenWhitespace, 22, 1, " "},
+		{tokenText, 23, 16, "Lunchtime doubly"}, {tokenWhitespace, 41, 1, " "}, {tokenText, 42, 3, "so."}, {tokenEOF, 45, 0, ""},
+	}
+	l := newLexer([]byte("Time is [an illusion]. [Lunchtime doubly] so."), nil, lexOptions{noBreakOpen: '[', noBreakClose: ']'})
+	var tokens []token
+	for {
+		token := l.nextToken()
+		tokens = append(tokens, token)
+		if token.typ == tokenEOF || token.typ == tokenError {
+			break
+		}
+	}
+	equal(t, 0, tokens, expected)
+}
+
+func TestLexLen(t *testing.T) {
+	wide := func(r rune) int {
+		if r >= '\uAC00' && r <= '\uD7A3' { // hangul syllables
+			return 2
+		}
+		return 1
+	}
+	tests := []struct {
+		input string
+		opts  lexOptions
+		lens  []int
+	}{
+		{"hello world", lexOptions{tabSize: 8}, []int{5, 1, 5, 0}},
+		{"hello\tworld", lexOptions{tabSize: 8}, []int{5, 8, 5, 0}},
+		{"hello\tworld", lexOptions{tabSize: 4}, []int{5, 4, 5, 0}},
+		{"hello\tworld", lexOptions{tabSize: 4, runeWidth: wide}, []int{5, 4, 5, 0}},
+		{"못\t알아 듣겠어요", lexOptions{tabSize: 4}, []int{1, 4, 2, 1, 4, 0}},
+		{"못\t알아 듣겠어요", lexOptions{tabSize: 4, runeWidth: wide}, []int{2, 4, 4, 1, 8, 0}},
+		{"Χαίρετε Здравствуйте", lexOptions{tabSize: 4, runeWidth: wide}, []int{7, 1, 12, 0}},
+		// the zero width non-joiner and joiner are zero width text
+		{"mi\u200Cxaham a\u200Db\u200Dc", lexOptions{tabSize: 4}, []int{7, 1, 3, 0}},
+		// as are the zero width space, word joiner, and zero width no-break
+		// space, which have their own break handling
+		{"a\u200Bb c\u2060d e\uFEFFf", lexOptions{tabSize: 4}, []int{1, 0, 1, 1, 2, 1, 2, 0}},
+	}
+	for i, test := range tests {
+		l := newLexer([]byte(test.input), nil, test.opts)
+		var lens []int
+		for {
+			token := l.nextToken()
+			lens = append(lens, token.len)
+			if token.typ == tokenEOF || token.typ == tokenError {
+				break
+			}
+		}
+		if len(lens) != len(test.lens) {
+			t.Errorf("%d: got %d tokens want %d", i, len(lens), len(test.lens))
+			continue
+		}
+		for j := range lens {
+			if lens[j] != test.lens[j] {
+				t.Errorf("%d:%d: got %d want %d", i, j, lens[j], test.lens[j])
+			}
+		}
+	}
+}
+
+func TestLexReset(t *testing.T) {
+	l := lex([]byte("this input's tokens aren't all received"))
+	l.nextToken()
+	for i, test := range lexTests {
+		l.reset([]byte(test.input))
+		var tokens []token
+		for {
+			token := l.nextToken()
+			tokens = append(tokens, token)
+			if token.typ == tokenEOF || token.typ == tokenError {
+				break
+			}
+		}
+		equal(t, i, tokens, test.tokens)
+	}
+}
+
+func consume(l *lexer) {
+	for {
+		token := l.nextToken()
+		if token.typ == tokenEOF || token.typ == tokenError {
+			return
+		}
+	}
+}
+
+// Run with -benchtime=100000x to lex 100k small inputs.
+func BenchmarkLexNew(b *testing.B) {
+	input := []byte("Time is an illusion. Lunchtime doubly so.")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		consume(lex(input))
+	}
+}
+
+func BenchmarkLexReset(b *testing.B) {
+	input := []byte("Time is an illusion. Lunchtime doubly so.")
+	l := lex(nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.reset(input)
+		consume(l)
+	}
+}
+
+func TestLexBackup(t *testing.T) {
+	l := &lexer{input: []byte("a\u2014b")}
+	l.next()
+	if r := l.next(); r != '\u2014' {
+		t.Fatalf("got %q want %q", r, '\u2014')
+	}
+	l.backup()
+	if l.pos != 1 {
+		t.Errorf("pos: got %d want 1", l.pos)
+	}
+	// peek is a next followed by a backup; it doesn't change pos.
+	if r := l.peek(); r != '\u2014' || l.pos != 1 {
+		t.Errorf("peek: got %q at %d want %q at 1", r, l.pos, '\u2014')
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a second backup to panic; pos is %d", l.pos)
+		}
+	}()
+	l.backup()
+}
+
+func TestAnsiLen(t *testing.T) {
+	tests := []struct {
+		s string
+		n int
+	}{
+		{"", 0},
+		{"\x1b", 0},
+		{"\x1b[", 0},
+		{"\x1b[m", 3},
+		{"\x1b[31mred", 5},
+		{"\x1b[1;31mred", 7},
+		{"\x1b[38;5;208morange", 11},
+		{"\x1b[31", 0},
+		{"\x1bM", 0},
+		{"red\x1b[31m", 0},
+	}
+	for i, test := range tests {
+		n := ansiLen(test.s)
+		if n != test.n {
+			t.Errorf("%d: %q: got %d want %d", i, test.s, n, test.n)
+		}
+	}
+}
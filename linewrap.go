@@ -12,8 +12,8 @@
 // under the License.
 
 // Package linewrap wraps text so that they are n characters, or less in
-// length. Wrapped lines can be indented or turned into comments; c, c++, and
-// shell style comments are supported.
+// length. Wrapped lines can be indented or turned into comments; c, c++,
+// shell, sql, and lisp style comments are supported.
 //
 // Any /r characters encountered will be elided during the wrapping process;
 // only /n is supported for new lines.
@@ -35,9 +35,11 @@
 // trailing spaces on a line will be elided. With the exception of indentation,
 // all leading whitespaces on a wrapped line will be elided.
 //
+//     line tabulation            U+000B
+//     form feed                  U+000C
 //     space                      U+0020
+//     next line                  U+0085
 //     ogham space mark           U+1680
-//     mongolian vowel separator  U+180E
 //     en quad                    U+2000
 //     em quad                    U+2001
 //     en space                   U+2002
@@ -50,18 +52,38 @@
 //     thin space                 U+2009
 //     hair space                 U+200A
 //     zero width space           U+200B
+//     line separator             U+2028
+//     paragraph separator        U+2029
 //     medium mathematical space  U+205F
 //     ideographic space          U+3000
 //
 // Exceptions to whitespace characters (no break will occur):
 //
 //     no-break space             U+00A0
-//     zero width no-break space  U+202F
+//     mongolian vowel separator  U+180E
+//     narrow no-break space      U+202F
+//     word joiner                U+2060
+//     zero width no-break space  U+FEFF
+//
+// No break will occur on either side of the word joiner or the zero width
+// no-break space either, e.g. at a space next to it; see the Wrapper's
+// NoBreakJoiner to use another rune instead of the zero width no-break space.
+//
+// Prior to Unicode 6.3, the mongolian vowel separator was a whitespace
+// character; it is now a format character. Setting the Wrapper's
+// MongolianVowelSeparatorBreaks to true restores the old behavior.
+//
+// The figure space, U+2007, is whitespace unless the Wrapper's
+// FigureSpaceNoBreak is true, in which case no break will occur.
 //
 // Line breaks may be inserted after a dash (hyphen) character. An em dash
-// (U+2014) can have a break before or after its occurrence but linewrap will
-// only break after its occurrence. A hyphen minus (U+002D) is not supposed to
-// break on a numeric context but linewrap does not make that differentiation.
+// (U+2014) can have a break before or after its occurrence but, by default,
+// linewrap will only break after its occurrence; the Wrapper's DashBreaks can
+// change where, or whether, a line is broken at any dash. A hyphen minus
+// (U+002D) is not supposed to break on a numeric context but linewrap does
+// not make that differentiation.
+// An en dash (U+2013) used as a range indicator, e.g. "10–20", is not broken
+// if the Wrapper's EnDashRangeNoBreak is true.
 //
 //     hyphen minus                            U+002D
 //     soft hyphen                             U+00AD
@@ -92,22 +114,45 @@
 package linewrap
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
-	LineLength = 80 // default line length
-	TabSize    = 8  // default tab size
+	LineLength = 80  // default line length
+	TabSize    = 8   // default tab size
+	Ellipsis   = "…" // default ellipsis for truncated output
 )
 
+var sgrReset = []byte("\x1b[0m")
+
+var markdownBreak = []byte("  ") // the canonical Markdown hard line break; see RespectMarkdownBreaks
+
+const blank = " \t\r\n" // input that only has these chars is blank; see String
+
 var (
-	cppComment    = []byte("// ")
-	shellComment  = []byte("# ")
+	cppComment    = []byte("//") // line comment markers; the text is separated from them by the CommentSeparator
+	shellComment  = []byte("#")
+	sqlComment    = []byte("--")
+	lispComment   = []byte(";;")
 	cCommentBegin = []byte("/*\n") // the comment begin is on a separate line
 	cCommentEnd   = []byte("*/\n") // the comment end
+	cInlineBegin  = []byte("/* ")  // the begin of a single line CComment; see WrapCSmart
+	cInlineEnd    = []byte(" */")  // the end of a single line CComment
 )
 
+// commentMarkers are the line comment markers that ReflowComment recognizes;
+// they're matched case insensitively, e.g. "REM" and "rem" are the same.
+var commentMarkers = []string{"//", "--", "\u2014", ";;", "#", "rem"}
+
 type CommentStyle int
 
 const (
@@ -115,6 +160,8 @@ const (
 	CPPComment                // C++ style line comment: //
 	ShellComment              // shell style line comment: #
 	CComment                  // c style block comment: /* */
+	SQLComment                // SQL style line comment: --
+	LispComment               // lisp style line comment: ;;
 )
 
 func (c CommentStyle) String() string {
@@ -127,59 +174,617 @@ func (c CommentStyle) String() string {
 		return "shell style comments"
 	case CComment:
 		return "c style comments"
+	case SQLComment:
+		return "sql style comments"
+	case LispComment:
+		return "lisp style comments"
 	default:
 		return fmt.Sprintf("invalid: %d style comments", c)
 	}
 }
 
 func ParseCommentStyle(s string) CommentStyle {
+	c, _ := parseCommentStyle(s)
+	return c
+}
+
+// ParseCommentStyleStrict is like ParseCommentStyle, except that an error is
+// returned if s isn't a known comment style, instead of NoComment. "none" is
+// NoComment.
+func ParseCommentStyleStrict(s string) (CommentStyle, error) {
+	c, ok := parseCommentStyle(s)
+	if !ok {
+		return NoComment, fmt.Errorf("unknown comment style: %q", s)
+	}
+	return c, nil
+}
+
+// parseCommentStyle returns the CommentStyle for s and whether s is a known
+// comment style.
+func parseCommentStyle(s string) (CommentStyle, bool) {
 	s = strings.ToLower(s)
 	switch s {
+	case "none":
+		return NoComment, true
 	case "c":
-		return CComment
+		return CComment, true
 	case "cpp", "c++":
-		return CPPComment
+		return CPPComment, true
 	case "shell", "perl":
+		return ShellComment, true
+	case "sql":
+		return SQLComment, true
+	case "lisp":
+		return LispComment, true
+	default:
+		return NoComment, false
+	}
+}
+
+// CommentStyleForFile returns the CommentStyle for the file's language, which
+// is inferred from its extension, e.g. ".go" files use CPPComment. If the
+// language isn't known, NoComment is returned.
+func CommentStyleForFile(filename string) CommentStyle {
+	base := strings.ToLower(filepath.Base(filename))
+	switch base {
+	case "makefile", "dockerfile":
+		return ShellComment
+	}
+	switch filepath.Ext(base) {
+	case ".c", ".h", ".css":
+		return CComment
+	case ".go", ".cc", ".cpp", ".cxx", ".hh", ".hpp", ".java", ".js", ".ts", ".rs", ".swift", ".kt", ".scala", ".cs", ".proto":
+		return CPPComment
+	case ".sh", ".bash", ".zsh", ".py", ".rb", ".pl", ".pm", ".r", ".yaml", ".yml", ".toml", ".conf", ".mk", ".tf":
 		return ShellComment
+	case ".sql":
+		return SQLComment
+	case ".lisp", ".lsp", ".cl", ".el", ".clj", ".scm", ".ss", ".rkt":
+		return LispComment
+	}
+	return NoComment
+}
+
+// TokenKind is the kind of a token processed by the Wrapper.
+type TokenKind int
+
+const (
+	TextToken    TokenKind = iota // anything that isn't one of the following
+	SpaceToken                    // a sequence of whitespace characters, unless it's only tabs
+	HyphenToken                   // a sequence of dash characters
+	NewlineToken                  // \n
+	TabToken                      // a sequence of tabs
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TextToken:
+		return "text"
+	case SpaceToken:
+		return "space"
+	case HyphenToken:
+		return "hyphen"
+	case NewlineToken:
+		return "newline"
+	case TabToken:
+		return "tab"
+	default:
+		return fmt.Sprintf("invalid: %d token kind", k)
+	}
+}
+
+// WrapError is returned when a line would exceed Length and the Wrapper's
+// StrictWidth is true.
+type WrapError struct {
+	Line   int    // the line, in the output, that would exceed Length; the first line is 1
+	Width  int    // the width of the line
+	Length int    // the max length of a line
+	Text   string // the text that would cause the line to exceed Length
+	// MinLength is the minimum Length that the input can be wrapped to: the
+	// width of its widest unbreakable text plus that of any prefix a line
+	// starts with.
+	MinLength int
+}
+
+func (e *WrapError) Error() string {
+	return fmt.Sprintf("line %d: width %d exceeds length %d: %q: minimum Length for this input is %d", e.Line, e.Width, e.Length, e.Text, e.MinLength)
+}
+
+// BreakContext is what a BreakDecider decides whether to break the line
+// before the next token on.
+type BreakContext struct {
+	Column     int       // the width of the current line, including any prefix
+	Value      string    // the next token
+	Kind       TokenKind // the kind of the next token
+	Width      int       // the width of the next token
+	Remaining  int       // the width that can still be added to the current line; it may be negative
+	PriorValue string    // the token before the next token; empty at the start of the input
+	PriorKind  TokenKind // the kind of the token before the next token
+}
+
+// BreakCandidate is a point at which a line can be broken; see Analyze.
+type BreakCandidate struct {
+	Offset int       // the byte offset, in the input, at which the line ends if it's broken here
+	Width  int       // the width of the line if it's broken here, and nowhere else, since the input line's start
+	Kind   TokenKind // what the line is broken at: SpaceToken, TabToken, HyphenToken, NewlineToken, or TextToken, for text that ends with a break after char
+}
+
+// LeadingSpace is how whitespace at the start of an input line, i.e. after a
+// new line in the input, is handled.
+type LeadingSpace int
+
+const (
+	ElideLeadingSpace    LeadingSpace = iota // leading whitespace is elided
+	KeepLeadingSpace                         // leading whitespace is kept as is, after any indent
+	CollapseLeadingSpace                     // leading whitespace is collapsed to a single space
+)
+
+func (l LeadingSpace) String() string {
+	switch l {
+	case ElideLeadingSpace:
+		return "elide"
+	case KeepLeadingSpace:
+		return "keep"
+	case CollapseLeadingSpace:
+		return "collapse"
+	default:
+		return fmt.Sprintf("invalid: %d leading space", l)
+	}
+}
+
+// BreakDirection is where a line can be broken at a dash; see DashBreaks.
+type BreakDirection int
+
+const (
+	DashBreakAfter  BreakDirection = iota // a line can be broken after the dash, which ends the line
+	DashBreakBefore                       // a line can be broken before the dash, which starts the next line
+	DashNoBreak                           // no break will occur at the dash
+)
+
+func (d BreakDirection) String() string {
+	switch d {
+	case DashBreakAfter:
+		return "after"
+	case DashBreakBefore:
+		return "before"
+	case DashNoBreak:
+		return "no break"
+	default:
+		return fmt.Sprintf("invalid: %d break direction", d)
+	}
+}
+
+// LengthUnit is the unit that Length, and the width of the text, is measured
+// in.
+type LengthUnit int
+
+const (
+	Columns LengthUnit = iota // display width; tabs are TabSize columns and each rune is 1 column, unless RuneWidth says otherwise
+	Bytes                     // the number of bytes in the UTF-8 encoded text
+	Runes                     // the number of runes; tabs are 1 rune
+)
+
+func (u LengthUnit) String() string {
+	switch u {
+	case Columns:
+		return "columns"
+	case Bytes:
+		return "bytes"
+	case Runes:
+		return "runes"
 	default:
-		return NoComment
+		return fmt.Sprintf("invalid: %d length unit", u)
 	}
 }
 
+// Transformer transforms text. It has the same methods as
+// golang.org/x/text/transform.Transformer so any of its Transformers, e.g.
+// norm.NFC, can be used.
+type Transformer interface {
+	Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error)
+	Reset()
+}
+
 // Wrapper wraps lines so that the output is lines of Length characters or less.
 type Wrapper struct {
 	Length       int    // Max length of the line.
 	tabSize      int    // The size of a tab, in chars.
 	indentText   []byte // The string used to indent wrapped lines; if empty no indent will be done.
-	indentLen    int    // the length, in chars, of the indent text. tabs in the indentText count as tabSize cars.
+	indentLen    int    // the display width of the indent text. tabs in the indentText count as tabSize cars.
 	CommentStyle        // the type of comment,
-	priorToken   token
-	l            int // the length of the current line, in chars
+	// LengthIncludesPrefix determines whether the comment prefix, e.g. "// ",
+	// counts against Length. If false, Length is the length of the text and
+	// the comment prefix is added on top of it. Defaults to true.
+	LengthIncludesPrefix bool
+	// ExpandCommentTabs replaces each tab in the text of a comment, i.e. when
+	// there is a CommentStyle, with TabSize spaces, so that the comment lines
+	// up the same way regardless of the reader's tab settings. As tabs are
+	// counted as TabSize columns wide, the line lengths don't change, unless
+	// the LengthUnit isn't Columns. Tabs in the indent text aren't expanded.
+	ExpandCommentTabs bool
+	// TabStops measures a tab in the text as advancing to the next tab stop,
+	// a multiple of TabSize columns from the start of the line, instead of
+	// as TabSize columns wide, so that lines are broken where they would be
+	// when the tabs are displayed. The tabs are kept in the output; with
+	// ExpandCommentTabs, they are replaced by the spaces to the next tab stop.
+	// It only applies when the LengthUnit is Columns.
+	TabStops bool
+	// SentencePerLine puts each sentence on its own line: a new line is
+	// started after any text ending in a '.', '?', or '!' that is followed by
+	// whitespace. Sentences that exceed Length are still wrapped.
+	SentencePerLine bool
+	// ClauseBreaks prefers breaking after clause punctuation, ',', ';', or
+	// ':', when a line needs to be wrapped. If the current line has a clause
+	// break point, the line is broken there instead of at the most recent
+	// whitespace.
+	ClauseBreaks bool
+	// MongolianVowelSeparatorBreaks treats the mongolian vowel separator,
+	// U+180E, as whitespace, which it was prior to Unicode 6.3; otherwise no
+	// break will occur at it.
+	MongolianVowelSeparatorBreaks bool
+	// FigureSpaceNoBreak treats the figure space, U+2007, as a non-breaking
+	// space, which is how it is typically used, e.g. in "1 000" to keep the
+	// digits of numbers together. By default, it is whitespace.
+	FigureSpaceNoBreak bool
+	// EnDashRangeNoBreak keeps an en dash, U+2013, that is used as a range
+	// indicator, e.g. in "10–20" or "Mon–Fri", together with the text on
+	// either side of it: no break will occur at an en dash that has text, not
+	// whitespace, on both sides of it. A spaced en dash, e.g. in "word – word",
+	// can still be broken.
+	EnDashRangeNoBreak bool
+	// DashBreaks sets where a line can be broken at a dash, by the dash, e.g.
+	// {'\u058A': DashBreakBefore} to break before the armenian hyphen, as
+	// Armenian line breaking may prefer. A dash that isn't in it is broken
+	// after, DashBreakAfter, which is the default.
+	DashBreaks map[rune]BreakDirection
+	// KeepAbbreviations keeps a common abbreviation, "e.g.", "i.e.", "etc.",
+	// or "vs.", on the same line as the word that follows it, when they fit
+	// on a line together, as if the space between them were checked by the
+	// KeepTogether func. It applies in addition to that func, if there is one.
+	// With SentencePerLine, an abbreviation doesn't end a sentence.
+	KeepAbbreviations bool
+	// PreferSpaceBreaks breaks a line at its last space, instead of after a
+	// dash that follows it, e.g. "well-" in "a well-known", when the text
+	// after the space, up to and including the dash, is no more than
+	// SpaceBreakSlack chars wide, so that words are kept whole unless that
+	// leaves too much of the line empty.
+	PreferSpaceBreaks bool
+	// SpaceBreakSlack is how far past the last space, in chars, a dash can be
+	// for the space to still be preferred; see PreferSpaceBreaks. If 0, the
+	// space is always preferred.
+	SpaceBreakSlack int
+	// ASCIISpaceOnly makes the space, U+0020, and the tab the only whitespace
+	// that a line can be broken at; all other whitespace, e.g. the em space,
+	// U+2003, the ideographic space, U+3000, and the zero width space, U+200B,
+	// is treated as text, so no break will occur at it. New lines are still
+	// breaks.
+	ASCIISpaceOnly bool
+	// StrictSpaces makes the whitespace that this package knows of the only
+	// whitespace that a line can be broken at. By default, a char that it
+	// doesn't know of, but that Unicode classifies as whitespace, e.g. one
+	// added in a later version of Unicode, is also a break point, unless it is
+	// a no-break space, e.g. U+00A0.
+	StrictSpaces bool
+	// StripZeroWidthSpace removes the zero width space, U+200B, from the
+	// output. It is still a point at which a line can be broken, but, as it
+	// is only a hint of where to break, it isn't written, even when no break
+	// occurs at it.
+	StripZeroWidthSpace bool
+	// MinWordsPerLine is the min number of words on each wrapped line, other
+	// than the last line of a paragraph, when feasible: when a line would have
+	// fewer words, words are moved to it from the end of the line before it,
+	// as long as they fit and that line keeps at least MinWordsPerLine words,
+	// e.g. to avoid a long word alone on a line in a narrow column. If 0,
+	// which is the default, lines are filled greedily.
+	MinWordsPerLine int
+	// SoftHyphenOnly makes the soft hyphens, U+00AD, in a word the only
+	// points at which it can be broken; no break will occur at any other
+	// dash. When a word is broken at a soft hyphen, the line ends with a
+	// hyphen, '-'; the soft hyphens that aren't used are elided.
+	SoftHyphenOnly bool
+	// BreakAfterSlash makes a slash, '/', a point at which a line can be
+	// broken, e.g. a long path, "/usr/local/share/doc", can be broken after
+	// any of its slashes. Slashes within a URL, e.g. "https://example.com/a",
+	// are not break points: a URL is kept whole. Nor is a slash between two
+	// digits, e.g. in the fraction "1/2" or the date "10/31/2024"; a slash
+	// between letters, e.g. in "and/or", is.
+	BreakAfterSlash bool
+	// PrefixBlankBlockLines determines whether blank lines within a CComment
+	// block get the block line prefix, without any trailing whitespace, e.g.
+	// " *". If false, blank lines within the block are empty.
+	PrefixBlankBlockLines bool
+	// TrailingCommentLine ends line comments, e.g. CPPComment, with a line
+	// that is only the comment marker, e.g. "//", as a visual end to the
+	// comment. If the text already ends with a blank comment line, that line
+	// is the end.
+	TrailingCommentLine bool
+	// StrictWidth makes it an error for a line to exceed Length, which can
+	// happen when a token is longer than Length. If a line would exceed
+	// Length, a *WrapError is returned instead of the wrapped text.
+	StrictWidth bool
+	// BreakLongWords breaks a word that is too long to fit on a line by
+	// itself, e.g. a long URL in a narrow column, across as many lines as it
+	// takes, instead of letting it exceed Length. The word starts on a new
+	// line; each line that it is broken at ends with the ContinuationChar, if
+	// there is one.
+	BreakLongWords bool
+	// ContinuationChar, if not 0, ends each line that a word was broken at by
+	// BreakLongWords, e.g. '\\' or '↵', so that the reader can tell an
+	// inserted break from a natural one. Its width is reserved on those lines.
+	ContinuationChar rune
+	// LeadingSpace determines how whitespace at the start of an input line is
+	// handled. By default, it is elided.
+	LeadingSpace LeadingSpace
+	// KeepLeadingTabs, with a LeadingSpace of KeepLeadingSpace, starts each
+	// line that an input line is wrapped onto with the input line's leading
+	// tabs, so that an indented line, e.g. code, stays indented. By default,
+	// the leading whitespace of a line that follows an inserted break is
+	// elided, whether it's spaces or tabs.
+	KeepLeadingTabs bool
+	// AutoIndent indents the wrapped lines with the leading whitespace of the
+	// input's first line, in place of the IndentText, so that indented text,
+	// e.g. a paragraph indented by 4 spaces, stays indented when it's
+	// reflowed. If the first line isn't indented, or is blank, the IndentText
+	// is used.
+	AutoIndent bool
+	// FillExact allows lines to be filled to exactly Length chars. By
+	// default, a line is wrapped when adding a token would make it Length
+	// chars, so lines are less than Length chars.
+	FillExact bool
+	// SplitIndicator ends each message that Split returns with its number and
+	// the number of messages, e.g. " (1/3)", when there is more than one. The
+	// indicator counts against the limit.
+	SplitIndicator bool
+	// PadToWidth pads each line of the output, with the fill char, see
+	// FillChar, to exactly Length chars, e.g. for fixed width records.
+	// Unlike justification, the padding is only added to the end of the
+	// line, after the text and before the suffix, if there is one; see
+	// WrapBetween. A line that is longer than Length, e.g. because of a word
+	// that couldn't be broken, is left as is.
+	PadToWidth bool
+	// IgnoreANSI excludes ANSI escape sequences, e.g. the SGR sequences used
+	// to color text, from the width of the text. The SGR state, e.g. the
+	// color, that is active at the end of a line is reset at the end of it
+	// and set again at the start of the next line, so that each line renders
+	// correctly on its own, e.g. when paged.
+	IgnoreANSI bool
+	// MinimizeDiff keeps the new lines in the input where they are, e.g. so
+	// that re-wrapping text that has already been wrapped results in a
+	// minimal diff. When a line has to be wrapped, the text that doesn't fit
+	// is carried over to the start of the next line, if all of that line fits
+	// after it; otherwise, the carried over text is on a line of its own.
+	// Lines are never joined just to fill them. If the LeadingSpace is
+	// KeepLeadingSpace, an indented line is never joined either, as its
+	// leading whitespace would be lost.
+	MinimizeDiff bool
+	// CSmartBlock makes WrapCSmart use a CComment block, instead of CPPComment
+	// line comments, for text that doesn't fit on a single line.
+	CSmartBlock bool
+	// TrimTrailing removes any trailing whitespace, e.g. tabs or non-breaking
+	// spaces, from each line, including the last one, so that no line ends
+	// with whitespace. Defaults to true.
+	TrimTrailing bool
+	// MaxLines is the max number of lines of wrapped text; the comment
+	// delimiters of a CComment block don't count as lines. If the wrapped text
+	// has more lines, it is truncated and the last line ends with the
+	// ellipsis; see Ellipsis. If 0, there is no max.
+	MaxLines int
+	// RespectMarkdownBreaks reflows the text like Markdown: a new line in the
+	// input is a space, unless it is part of a paragraph break, i.e. a blank
+	// line, or it ends a line with a hard line break, two or more spaces or a
+	// backslash. Hard line breaks are kept, ending with either two spaces or
+	// the backslash, even if TrimTrailing is true.
+	RespectMarkdownBreaks bool
+	// DetectTables keeps table rows as they are, without wrapping them, so that
+	// the table's columns stay aligned, while the rest of the text is wrapped.
+	// Rows are lines that start with a '|', e.g. Markdown tables, or a "+-" or
+	// "+=", e.g. the borders of ASCII tables, and consecutive lines whose
+	// columns, text that follows two or more spaces, are aligned.
+	DetectTables bool
+	// Verbatim, if not nil, is called with each line of the input, without its
+	// new line; the lines that it returns true for are kept exactly as they
+	// are: they aren't wrapped, they may exceed Length, and none of their
+	// whitespace is elided. The lines are still indented and prefixed, e.g.
+	// with the comment prefix. It can be used to protect code blocks, tables,
+	// or any other lines that need to be kept as is.
+	Verbatim func(line string) bool
+	// PreserveCodeBlocks keeps indented code blocks as they are, like
+	// Verbatim, while the rest of the text is wrapped. As in Markdown, a code
+	// block starts with a line that is indented by four or more spaces, or a
+	// tab, that follows a blank line or starts the input, and continues
+	// through the indented and blank lines that follow it. An indented line
+	// within a paragraph, e.g. indented prose, doesn't start a code block.
+	PreserveCodeBlocks bool
+	// ProtectPlaceholders keeps fmt verbs, e.g. "%-10.2f", and template
+	// actions, e.g. "{{ .Name }}", whole, so that a format string or template
+	// can be wrapped without breaking them: no break will occur within them,
+	// even at a space or a dash.
+	ProtectPlaceholders bool
+	// EmailQuotes reflows quoted email text, i.e. lines that start with a run
+	// of '>', one per level of quoting, e.g. "> > text" or ">> text". Each run
+	// of lines with the same quote depth is reflowed separately: its lines are
+	// joined, see Unwrap, and wrapped so that, with its quote marker, e.g.
+	// ">> ", each line fits in Length. The marker, as the run's first line
+	// has it but with a single trailing space, starts every wrapped line.
+	// Lines that aren't quoted are wrapped as usual. Any label or indent text
+	// is replaced by the quote marker.
+	EmailQuotes bool
+	// MaxBlankLines is the max number of consecutive blank lines; any more
+	// blank lines in the input are elided, e.g. with a MaxBlankLines of 1,
+	// paragraphs are separated by a single blank line. If 0, there is no max.
+	MaxBlankLines int
+	// LengthUnit is the unit that Length is in, e.g. Bytes for text that has
+	// to fit a field of a fixed size. For Bytes and Runes, every byte or rune
+	// counts, including those of any ANSI escape sequences. Defaults to
+	// Columns.
+	LengthUnit LengthUnit
+
+	onToken      func(kind TokenKind, value string) // called for each token; see OnToken
+	noBreakOpen  rune                               // the start of a no break region; 0 if there are none
+	noBreakClose rune                               // the end of a no break region
+	joiner       rune                               // no break occurs on either side of it; see NoBreakJoiner
+	breakAfter   string                             // the chars that a break can also occur after; see BreakAfter
+	fill         rune                               // the rune lines are padded with; see FillChar
+	locale       string                             // the language whose line breaking rules are used; see Locale
+	runeWidth    func(r rune) int                   // returns the width of a rune; see RuneWidth
+	transformer  Transformer                        // applied to text tokens; used by WrapTransform
+	blockPrefix  []byte                             // the prefix for each line within a CComment block
+	label        []byte                             // the text the first line starts with; see AlignUnder
+	suffix       []byte                             // the text the last line ends with; see WrapBetween
+	keepTogether func(left, right string) bool      // whether the text on either side of a space must stay together; see KeepTogether
+	breakDecider func(ctx BreakContext) bool        // whether to break the line before a token; see BreakDecider
+	softBreak    []byte                             // the marker that ends lines broken by the wrapper; see SoftBreakMarker
+	newline      []byte                             // the line ending of the output; nil is "\n"; see Newline
+	ellipsis     []byte                             // the end of the last line of truncated output; see MaxLines
+	commentSep   []byte                             // what separates a line comment's marker from the text; see CommentSeparator
+	paraSep      string                             // the lines between paragraphs; see ParagraphSeparator
+	boxCorners   []rune                             // the top left, top right, bottom left, and bottom right corners of a box; see BoxChars
+	boxEdge      string                             // the top and bottom edges of a box; see BoxChars
+	boxSide      string                             // the left and right sides of a box; see BoxChars
+
+	priorToken  token        // the last token written to b
+	l           int          // the length of the current line, in chars
+	sentenceEnd bool         // whether a sentence just ended; used by SentencePerLine
+	spaceBreak  bool         // whether the line is broken before the next token that isn't a space; see wrap
+	clause      breakPoint   // the most recent clause break point on the current line; used by ClauseBreaks
+	bol         int          // the index in b at which the current line's text begins
+	left        string       // the most recent text written to b; used by KeepTogether
+	space       breakPoint   // the most recent space break point on the current line; used by KeepTogether and PreferSpaceBreaks
+	prevSpace   breakPoint   // the space break point prior to space; used by KeepTogether
+	word        breakPoint   // the space break point before the current word; used by SoftHyphenOnly
+	held        bool         // whether the space last written to b exceeds the line; used by KeepTogether
+	widest      int          // the width of the widest text written to b; used by StrictWidth
+	sgr         []byte       // the active SGR escape sequences; used by IgnoreANSI
+	softSinceNL bool         // whether there has been a soft break since the last hard one; used by MinimizeDiff
+	joined      bool         // whether the last new line in the input was replaced by a space; used by MinimizeDiff
+	lines       int          // the number of the current line; used by MaxLines
+	cut         int          // the index in b of the end of line MaxLines; used by MaxLines
+	truncated   bool         // whether text was cut after line MaxLines; used by FitBox
+	cutBOL      int          // the index in b at which the text of line MaxLines begins; used by MaxLines
+	continued   bool         // whether b already has the start of the output; used by WrapFrom
+	delimited   bool         // whether the output has the CComment begin and end; see Delimited
+	blanks      int          // the number of consecutive blank lines; used by MaxBlankLines
+	elided      int          // the number of whitespace chars elided; see ElidedSpaces
+	kept        bool         // whether the current line was kept as is; its trailing whitespace isn't trimmed
+	prevBOL     int          // the index in b of the start of the prior line's text; used by MinWordsPerLine
+	prevEOL     int          // the index in b of the end of the prior line's text; used by MinWordsPerLine
+	spaces      []breakPoint // the space break points on the current line; used by MinWordsPerLine
+	prevSpaces  []breakPoint // the space break points on the prior line; used by MinWordsPerLine
+	count       bool         // whether the output is only counted; see OutputSize
+	counted     int          // the size, in bytes, of the output that was counted and dropped from b; see OutputSize
+	countedNL   int          // the number of new lines in the counted output; see OutputSize
+	prevStart   int          // the index in b of the start of the prior line, if the lines before it can be counted; see flush
+	pending     token        // the token read ahead of the current one; see read
+	leadTabs    string       // the leading tabs of the input line; see KeepLeadingTabs
 	*lexer
 	b []byte
 }
 
+// breakPoint is a position in the current line at which it can be broken.
+type breakPoint struct {
+	pos  int    // the index in b of the whitespace at the break point; 0 means no break point
+	next int    // the index in b of what follows the whitespace
+	l    int    // the length of the line, in chars, up to next
+	sgr  string // the active SGR escape sequences at the break point; used by IgnoreANSI
+}
+
+// shift moves the break point, if it is set, by n bytes.
+func (bp *breakPoint) shift(n int) {
+	if bp.pos != 0 {
+		bp.pos += n
+		bp.next += n
+	}
+}
+
 // New returns a new Wrap with default Length and TabWidth.
 func New() *Wrapper {
 	return &Wrapper{
-		Length:  LineLength,
-		tabSize: TabSize,
+		Length:               LineLength,
+		tabSize:              TabSize,
+		LengthIncludesPrefix: true,
+		TrimTrailing:         true,
+		ellipsis:             []byte(Ellipsis),
+		commentSep:           []byte(" "),
+		boxCorners:           []rune("++++"),
+		boxEdge:              "-",
+		boxSide:              "|",
+		joiner:               '\uFEFF',
+		fill:                 ' ',
 	}
 }
 
+// NewFromEnv returns a new Wrapper, like New, whose Length is the value of
+// the COLUMNS environment variable and whose tab size is the value of the
+// LINEWRAP_TABSIZE environment variable, e.g. for a tool that wraps text to
+// the width of the terminal. A variable that isn't set, or whose value isn't
+// a positive integer, is ignored: the default, LineLength or TabSize, is used.
+func NewFromEnv() *Wrapper {
+	w := New()
+	if n, ok := envInt("COLUMNS"); ok {
+		w.Length = n
+	}
+	if n, ok := envInt("LINEWRAP_TABSIZE"); ok {
+		w.TabSize(n)
+	}
+	return w
+}
+
+// envInt returns the value of the environment variable key, if it is a
+// positive integer.
+func envInt(key string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
 // Reset resets the non-configuration fields so that it's usable for a new
-// input. The Wrapper's configuration is not affected.
+// input. The Wrapper's configuration is not affected. The lexer is kept; it
+// is reset for each input.
 func (w *Wrapper) Reset() {
-	w.lexer = nil
 	w.b = w.b[:0]
 	w.l = 0
+	w.priorToken = token{}
+	w.sentenceEnd = false
+	w.spaceBreak = false
+	w.clause = breakPoint{}
+	w.bol = 0
+	w.left = ""
+	w.space = breakPoint{}
+	w.prevSpace = breakPoint{}
+	w.word = breakPoint{}
+	w.held = false
+	w.widest = 0
+	w.sgr = w.sgr[:0]
+	w.softSinceNL = false
+	w.joined = false
+	w.lines = 0
+	w.cut = 0
+	w.truncated = false
+	w.cutBOL = 0
+	w.delimited = false
+	w.blanks = 0
+	w.elided = 0
+	w.kept = false
+	w.prevBOL = 0
+	w.prevEOL = 0
+	w.spaces = w.spaces[:0]
+	w.prevSpaces = w.prevSpaces[:0]
+	w.counted = 0
+	w.countedNL = 0
+	w.prevStart = 0
+	w.pending = token{}
+	w.leadTabs = ""
 }
 
 // String returns a wrapped string. The resulting string will be consistent
-// with Wrap's configuration.
+// with Wrap's configuration. If s is empty, or blank, i.e. it only has spaces,
+// tabs, and new lines, the result is empty, regardless of the CommentStyle.
 func (w *Wrapper) String(s string) (string, error) {
-	if s == "" { // if the string is empty, no comment
-		return s, nil
+	if isBlank(s) { // if the string is empty, no comment
+		w.delimited = false
+		return "", nil
 	}
 	b, err := w.Bytes([]byte(s))
 	if err != nil {
@@ -190,191 +795,2652 @@ func (w *Wrapper) String(s string) (string, error) {
 
 // Wrap bytes and return the wrapped bytes
 func (w *Wrapper) Bytes(s []byte) (b []byte, err error) {
-	if len(s) == 0 { // if the string is empty, no comment
-		return s, nil
+	if len(bytes.Trim(s, blank)) == 0 { // if the string is empty, no comment
+		w.delimited = false
+		return s[:0], nil
+	}
+	if w.locale == "fr" {
+		s = []byte(frenchSpacing(string(s)))
+	}
+	if w.EmailQuotes {
+		return w.emailQuotes(s)
+	}
+	w.setLexer(s, nil)
+	b, err = w.process(len(s))
+	if err != nil {
+		return b, err
+	}
+	return w.endLines(b), nil
+}
+
+// Runes wraps runes and returns the wrapped runes. The runes are lexed
+// directly; they are not encoded to UTF-8 first.
+func (w *Wrapper) Runes(rs []rune) ([]rune, error) {
+	if isBlank(string(rs)) { // if the input is empty, no comment
+		w.delimited = false
+		return rs[:0], nil
+	}
+	if w.locale == "fr" {
+		rs = []rune(frenchSpacing(string(rs)))
+	}
+	if w.EmailQuotes {
+		b, err := w.emailQuotes([]byte(string(rs)))
+		if err != nil {
+			return nil, err
+		}
+		return []rune(string(b)), nil
+	}
+	w.setLexer(nil, rs)
+	b, err := w.process(len(rs))
+	if err != nil {
+		return nil, err
+	}
+	return []rune(string(w.endLines(b))), nil
+}
+
+// emailQuotes wraps s, email text with quoted lines; see EmailQuotes. Each
+// run of lines with the same quote depth is wrapped separately, with its
+// quote marker as the label and indent text.
+func (w *Wrapper) emailQuotes(s []byte) ([]byte, error) {
+	c := w.config()
+	c.EmailQuotes = false
+	c.newline = nil
+	var b []byte
+	lines := strings.Split(string(s), "\n")
+	for i := 0; i < len(lines); {
+		depth := quoteDepth(lines[i])
+		j := i + 1
+		for j < len(lines) && quoteDepth(lines[j]) == depth {
+			j++
+		}
+		if i > 0 {
+			b = append(b, nl)
+		}
+		marker := quoteMarker(lines[i])
+		text := make([]string, 0, j-i)
+		for _, line := range lines[i:j] {
+			text = append(text, stripQuote(line))
+		}
+		region := strings.Join(text, "\n")
+		i = j
+		if isBlank(region) { // the blank lines are kept, without whitespace
+			b = append(b, strings.Repeat("\n", len(text)-1)...)
+			continue
+		}
+		c.Reset()
+		c.label = nil
+		c.IndentText("")
+		if depth > 0 {
+			region = Unwrap(region, false)
+			c.label = []byte(marker)
+			c.IndentText(marker)
+		}
+		wrapped, err := c.Bytes([]byte(region))
+		if err != nil {
+			return nil, err
+		}
+		w.counted += c.counted
+		w.countedNL += c.countedNL
+		b = append(b, wrapped...)
+	}
+	return w.endLines(b), nil
+}
+
+// quoteDepth returns the quote depth of an email line: the number of '>' in
+// the run of '>' and spaces that the line starts with.
+func quoteDepth(line string) int {
+	var depth int
+	for _, r := range line {
+		switch r {
+		case '>':
+			depth++
+		case ' ':
+		default:
+			return depth
+		}
+	}
+	return depth
+}
+
+// quoteMarker returns the quote marker of an email line, the run of '>' and
+// spaces that it starts with, ending with a single space, e.g. "> > ". A line
+// that isn't quoted has no marker.
+func quoteMarker(line string) string {
+	if quoteDepth(line) == 0 {
+		return ""
+	}
+	marker := line[:len(line)-len(strings.TrimLeft(line, "> "))]
+	return strings.TrimRight(marker, " ") + " "
+}
+
+// stripQuote returns the line without its quote marker, the run of '>' and
+// spaces that it starts with; a line that isn't quoted is returned as is.
+func stripQuote(line string) string {
+	if quoteDepth(line) == 0 {
+		return line
+	}
+	return strings.TrimLeft(line, "> ")
+}
+
+// isBlank returns whether s is empty or only has blank chars.
+func isBlank(s string) bool {
+	return strings.Trim(s, blank) == ""
+}
+
+// setLexer sets up w's lexer for either input or runes. The lexer from a
+// prior input is reused, if there is one, to avoid allocating a new one for
+// each input.
+func (w *Wrapper) setLexer(input []byte, runes []rune) {
+	w.pending = token{}
+	if w.lexer == nil {
+		w.lexer = newLexer(input, runes, w.lexOptions())
+		return
+	}
+	// the prior input must be done being lexed before the options change.
+	w.lexer.drain()
+	w.lexer.lexOptions = w.lexOptions()
+	if runes != nil {
+		w.lexer.resetRunes(runes)
+		return
+	}
+	w.lexer.reset(input)
+}
+
+// lexOptions returns the lexer options for w's configuration.
+func (w *Wrapper) lexOptions() lexOptions {
+	return lexOptions{
+		tabSize:            w.tabSize,
+		runeWidth:          w.runeWidth,
+		mvsBreaks:          w.MongolianVowelSeparatorBreaks,
+		figureSpaceNoBreak: w.FigureSpaceNoBreak,
+		enDashRangeNoBreak: w.EnDashRangeNoBreak,
+		dashBreaks:         w.DashBreaks,
+		softHyphenOnly:     w.SoftHyphenOnly,
+		breakAfterSlash:    w.BreakAfterSlash,
+		breakAfter:         w.breakAfter,
+		asciiSpaceOnly:     w.ASCIISpaceOnly,
+		strictSpaces:       w.StrictSpaces,
+		ignoreANSI:         w.IgnoreANSI,
+		unit:               w.LengthUnit,
+		noBreakOpen:        w.noBreakOpen,
+		noBreakClose:       w.noBreakClose,
+		joiner:             w.joiner,
+		placeholders:       w.ProtectPlaceholders,
+	}
+}
+
+// verbatim writes the input line that t starts as is, without wrapping it,
+// and returns the token that ends the line, either a new line or EOF.
+func (w *Wrapper) verbatim(t token) token {
+	line := w.lexer.line(t.pos)
+	w.b = append(w.b, line...)
+	w.l += w.lexOptions().measure(line)
+	w.priorToken = token{tokenText, t.pos, w.l, line}
+	w.kept = true
+	for t.typ != tokenNL && t.typ != tokenEOF && t.typ != tokenError {
+		t = w.read()
+	}
+	return t
+}
+
+// keptLines returns whether each line of the input is kept as is, either
+// because it is a table row, see DetectTables, it is in a code block, see
+// PreserveCodeBlocks, or because Verbatim returns true for it.
+func (w *Wrapper) keptLines() []bool {
+	lines := strings.Split(w.lexer.text(), "\n")
+	var rows []bool
+	if w.DetectTables {
+		rows = tableRows(lines)
+	} else {
+		rows = make([]bool, len(lines))
+	}
+	if w.PreserveCodeBlocks {
+		for i, code := range codeBlocks(lines) {
+			rows[i] = rows[i] || code
+		}
+	}
+	if w.Verbatim == nil {
+		return rows
+	}
+	for i, line := range lines {
+		if !rows[i] {
+			rows[i] = w.Verbatim(strings.TrimRight(line, "\r"))
+		}
+	}
+	return rows
+}
+
+// codeBlocks returns whether each of the lines is in an indented code block;
+// see PreserveCodeBlocks. The blank lines that end a code block aren't in it.
+func codeBlocks(lines []string) []bool {
+	code := make([]bool, len(lines))
+	var in bool   // whether the prior line is in a code block
+	blank := true // whether the prior line is blank; the input's start is like a blank line
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		indented := strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+		switch {
+		case isBlank(line):
+		case indented && (in || blank):
+			in = true
+		default:
+			in = false
+		}
+		code[i] = in && !isBlank(line)
+		blank = isBlank(line)
+	}
+	return code
+}
+
+// tableRows returns whether each of the lines is a table row: it either
+// starts with a '|', e.g. a Markdown table row, or a '+', e.g. the border of
+// an ASCII table, or it and an adjacent line have columns that are aligned:
+// a run of two or more spaces ends at the same column in both.
+func tableRows(lines []string) []bool {
+	rows := make([]bool, len(lines))
+	cols := make([]map[int]bool, len(lines))
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "|") || strings.HasPrefix(trimmed, "+-") || strings.HasPrefix(trimmed, "+=") {
+			rows[i] = true
+		}
+		cols[i] = columns(line)
+		if i == 0 {
+			continue
+		}
+		for col := range cols[i] {
+			if cols[i-1][col] {
+				rows[i-1], rows[i] = true, true
+				break
+			}
+		}
+	}
+	return rows
+}
+
+// columns returns the columns, in runes, at which text follows a run of two or
+// more spaces within line; its leading and trailing whitespace is ignored.
+func columns(line string) map[int]bool {
+	cols := make(map[int]bool)
+	var col, spaces int
+	text := false
+	for _, r := range strings.TrimRight(line, " \t") {
+		switch {
+		case r == ' ':
+			spaces++
+		default:
+			if text && spaces > 1 {
+				cols[col] = true
+			}
+			text = true
+			spaces = 0
+		}
+		col++
+	}
+	return cols
+}
+
+// read returns the next token from w's lexer. Text that is joined by narrow
+// no-break spaces, e.g. "1\u202F000", is returned as a single text token, as
+// no break can occur within it.
+func (w *Wrapper) read() token {
+	t := w.pending
+	w.pending = token{}
+	if t.typ == tokenNone {
+		t = w.lexer.nextToken()
+	}
+	if t.typ != tokenText && t.typ != tokenNarrowNoBreakSpace {
+		return t
+	}
+	for {
+		next := w.lexer.nextToken()
+		if next.typ != tokenText && next.typ != tokenNarrowNoBreakSpace {
+			w.pending = next
+			break
+		}
+		t.typ = tokenText
+		t.value += next.value
+		t.len += next.len
 	}
+	return t
+}
 
-	// if b hasn't already been allocated, do an initial allocation.
-	if w.b == nil {
-		w.b = make([]byte, 0, len(s))
+// process wraps the tokens from w's lexer and returns the wrapped bytes; n is
+// the size of the input.
+func (w *Wrapper) process(n int) ([]byte, error) {
+	// if b hasn't already been allocated, do an initial allocation; if the
+	// output is only counted, b only has the last lines, see flush.
+	if w.b == nil && !w.count {
+		w.b = make([]byte, 0, n)
 	}
 
 	// If there's a comment type; lead with that. If CommentType == none, nothing
-	// will be done.
-	w.commentBegin()
+	// will be done. If the output is being continued, it's already been done.
+	w.delimited = false
+	if !w.continued {
+		w.commentBegin()
+		w.lines = 1
+		if len(w.label) > 0 {
+			w.b = append(w.b, w.label...)
+			w.l += w.lexOptions().measure(string(w.label))
+		}
+	}
+
+	if w.AutoIndent && !w.continued {
+		line := w.lexer.line(0)
+		if lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]; lead != "" && lead != line {
+			defer w.IndentText(string(w.indentText))
+			w.IndentText(lead)
+		}
+	}
 
 	var (
-		skip bool
-		tkn  token
+		skip   bool
+		shy    bool // whether tkn follows a soft hyphen; used by SoftHyphenOnly
+		tkn    token
+		rows   []bool // whether each input line is kept as is; used by DetectTables and Verbatim
+		inLine int    // the current input line
 	)
+	if w.DetectTables || w.Verbatim != nil || w.PreserveCodeBlocks {
+		rows = w.keptLines()
+	}
 
-	w.lexer = lex(s)
 	for {
-		w.priorToken = tkn
-		tkn = w.lexer.nextToken()
+		if w.prevStart > 0 {
+			w.flush()
+		}
+		tkn = w.read()
+		kept := false // whether the line was kept as is; its new line is too
+		if tkn.typ == tokenNL {
+			inLine++
+		} else if rows != nil && rows[inLine] && (w.priorToken.typ == tokenNL || w.priorToken.typ == tokenNone) {
+			tkn = w.verbatim(tkn)
+			if tkn.typ == tokenNL {
+				inLine++
+			}
+			kept = true
+		}
 		if tkn.typ == tokenEOF { // if eof has been reached, stop processing
 			break
 		}
+		if w.onToken != nil && tkn.typ != tokenError {
+			w.onToken(tkn.kind(), tkn.value)
+		}
+		// if text was carried over to this line, the next line is joined to
+		// it, if all of it fits; otherwise the input's new line is kept.
+		if w.MinimizeDiff && tkn.typ == tokenNL && w.softSinceNL {
+			line := w.lexer.line(tkn.pos + 1)
+			next := strings.TrimSpace(line)
+			indented := w.LeadingSpace == KeepLeadingSpace && !strings.HasPrefix(line, next)
+			if next != "" && !indented && w.fits(1+w.lexOptions().measure(next)) {
+				tkn = token{tokenWhitespace, tkn.pos, 1, " "}
+				w.softSinceNL = false
+				w.joined = true
+			}
+		}
+		if w.RespectMarkdownBreaks && tkn.typ == tokenNL && !kept && !w.isMarkdownBreak(tkn) {
+			w.joined = true
+			if w.priorToken.typ == tokenWhitespace {
+				continue // the line already ends with a space
+			}
+			tkn = token{tokenWhitespace, tkn.pos, 1, " "}
+		}
+		if w.StripZeroWidthSpace && strings.Contains(tkn.value, zeroWidthSpace) {
+			tkn.value = strings.Replace(tkn.value, zeroWidthSpace, "", -1)
+			tkn.len = w.lexOptions().measure(tkn.value)
+		}
+		afterShy := shy
+		shy = false
 		switch tkn.typ {
-		case tokenSpace:
+		case tokenSoftHyphen:
+			shy = true
+			continue
+		case tokenWhitespace:
+			if w.joined && w.priorToken.typ == tokenWhitespace {
+				continue // the joined line's leading whitespace
+			}
+			// the input line's leading tabs, including the first line's.
+			if w.KeepLeadingTabs && w.LeadingSpace == KeepLeadingSpace && (w.priorToken.typ == tokenNL || w.priorToken.typ == tokenNone) {
+				w.leadTabs = tkn.value[:len(tkn.value)-len(strings.TrimLeft(tkn.value, "\t"))]
+			}
 			if w.priorToken.typ == tokenNL {
+				switch w.LeadingSpace {
+				case KeepLeadingSpace:
+				case CollapseLeadingSpace:
+					tkn.value = " "
+					tkn.len = 1
+				default:
+					w.elide(tkn.value)
+					continue
+				}
+			}
+			expand := w.ExpandCommentTabs && w.CommentStyle != NoComment && strings.IndexByte(tkn.value, tab) >= 0
+			switch {
+			case w.TabStops && w.LengthUnit == Columns && w.tabSize > 0:
+				var expanded string
+				expanded, tkn.len = w.tabStops(tkn.value)
+				if expand {
+					tkn.value = expanded
+				}
+			case expand:
+				tkn.value = strings.Replace(tkn.value, "\t", strings.Repeat(" ", w.tabSize), -1)
+				tkn.len = w.lexOptions().measure(tkn.value)
+			}
+			// a sentence ending is a break point; the space is elided.
+			if w.SentencePerLine && w.isSentenceEnd(w.priorToken) {
+				w.sentenceEnd = true
 				continue
 			}
 		case tokenNL:
-			w.nl()
+			w.leadTabs = ""
+			if w.MaxBlankLines > 0 {
+				if len(w.b) > w.bol {
+					w.blanks = 0
+				} else if w.blanks++; w.blanks > w.MaxBlankLines {
+					continue
+				}
+			}
+			if w.RespectMarkdownBreaks && isTwoSpaceBreak(w.priorToken) {
+				w.newLine(markdownBreak)
+				w.softSinceNL = false
+			} else {
+				w.nl()
+			}
+			w.priorToken = tkn
 			continue
 		case tokenEOF:
 			goto done
 		case tokenError:
 			return w.b, tkn
 		}
-		skip = w.wrap(&tkn)
-		if skip {
+		if w.transformer != nil && tkn.typ == tokenText {
+			err := w.transform(&tkn)
+			if err != nil {
+				w.lexer.drain()
+				return w.b, err
+			}
+		}
+		if w.sentenceEnd {
+			w.softNL()
+		}
+		if w.spaceBreak {
+			if isSpace(tkn.typ) {
+				w.elide(tkn.value)
+				continue
+			}
+			w.softNL()
+		}
+		if afterShy && tkn.typ == tokenText && len(w.b) > w.bol {
+			w.hyphenate(tkn.len)
+		} else if skip = w.wrap(&tkn); skip {
+			w.elide(tkn.value)
 			continue
 		}
+		if w.BreakLongWords && tkn.typ == tokenText && !w.fits(tkn.len) {
+			w.breakLongWord(&tkn)
+		}
+		// there's more text than fits in MaxLines lines.
+		if w.MaxLines > 0 && w.lines > w.MaxLines {
+			w.truncate(true)
+			// the rest of the input isn't read; the lexer must still finish
+			// so that its go routine exits.
+			w.lexer.drain()
+			goto done
+		}
+		w.held = w.keepsTogether() && tkn.typ == tokenWhitespace && !w.fits(tkn.len)
+		if w.StrictWidth && !w.held && w.l+tkn.len > w.lineLength() {
+			return nil, w.widthError(tkn)
+		}
 		w.b = append(w.b, tkn.String()...)
 		w.l += tkn.len
+		if !isSpace(tkn.typ) && tkn.len > w.widest {
+			w.widest = tkn.len
+		}
+		if w.IgnoreANSI && tkn.typ == tokenText {
+			w.updateSGR(tkn.value)
+		}
+		if !isSpace(tkn.typ) {
+			w.joined = false
+		}
+		if w.ClauseBreaks && tkn.typ == tokenWhitespace && isClauseEnd(w.priorToken) {
+			w.clause = w.breakPoint(tkn)
+		}
+		if w.SoftHyphenOnly && tkn.typ == tokenWhitespace {
+			w.word = w.breakPoint(tkn)
+		}
+		if w.keepsTogether() || w.PreferSpaceBreaks {
+			switch tkn.typ {
+			case tokenText:
+				w.left = tkn.value
+			case tokenWhitespace:
+				w.prevSpace = w.space
+				w.space = w.breakPoint(tkn)
+			}
+		}
+		if w.MinWordsPerLine > 1 && tkn.typ == tokenWhitespace {
+			w.spaces = append(w.spaces, w.breakPoint(tkn))
+		}
+		w.priorToken = tkn
+	}
+
+	// a held space that ends the input is trailing whitespace.
+	if w.held {
+		w.b = w.b[:len(w.b)-len(w.priorToken.value)]
+	}
+	// any lines past MaxLines are blank; they are elided.
+	if w.MaxLines > 0 && w.lines > w.MaxLines {
+		w.truncate(false)
+	}
+
+	if len(w.suffix) > 0 {
+		w.appendSuffix()
 	}
 
 done:
+	// the CComment end is on its own line; the last line of text was
+	// trimmed when it was ended.
+	if w.CommentStyle != CComment {
+		w.cleanBlankIndentLine()
+		w.trimTrailing()
+	}
 	w.commentEnd()
+	if w.PadToWidth {
+		w.padLines()
+	}
 
 	return w.b, nil
 }
 
-// Sets the tabsize for line length calculations, when a tab is encountered.
-// Actual tabsize may vary.  See TabSize for the default value.
-func (w *Wrapper) TabSize(i int) {
-	w.tabSize = i
-	w.setIndentLen() // the indent len may need to be updated
+// WrapFrom returns s with only the text from offset on wrapped; the text
+// before offset is assumed to already be wrapped and is left as is, e.g. when
+// only the end of a large document has changed. The offset is a byte offset
+// into s; it may be anywhere in a line, as long as it is at the start of a
+// UTF-8 encoded rune. If offset is within a word, it is moved back to the
+// start of the word, so that the word is wrapped as a whole. The wrapping
+// continues from the column that the text before offset ends at, with the
+// state that it ends with, e.g. within a comment. w is not changed.
+func (w *Wrapper) WrapFrom(s string, offset int) (string, error) {
+	if offset < 0 || offset > len(s) {
+		return "", fmt.Errorf("offset %d out of range: the length of the string is %d", offset, len(s))
+	}
+	if offset < len(s) && !utf8.RuneStart(s[offset]) {
+		return "", fmt.Errorf("offset %d is not at the start of a rune", offset)
+	}
+	if offset == len(s) {
+		return s, nil
+	}
+	c := w.config()
+	offset = c.wordStart(s, offset)
+	if offset == 0 {
+		return c.String(s)
+	}
+	c.continued = true
+	c.b = append(make([]byte, 0, len(s)), s[:offset]...)
+	c.l = c.lexOptions().measure(string(c.b[c.lineStart():]))
+	c.lines = bytes.Count(c.b, []byte{nl}) + 1
+	c.bol = c.lineStart()
+	if line := c.b[c.bol:]; len(line) == 0 || bytes.Equal(line, c.linePrefix()) {
+		c.bol = len(c.b)
+	}
+	// the prior token determines how the text at offset is handled, e.g.
+	// whether trailing whitespace is elided.
+	switch prior := c.b[len(c.b)-1]; {
+	case prior == nl:
+		c.priorToken = token{typ: tokenNL, value: "\n"}
+	case prior == ' ':
+		n := len(c.b) - len(bytes.TrimRight(c.b, " "))
+		c.priorToken = token{typ: tokenWhitespace, len: n, value: strings.Repeat(" ", n)}
+	default:
+		c.priorToken = token{typ: tokenText}
+	}
+	rest := s[offset:]
+	if !isBlank(rest) {
+		return c.String(rest)
+	}
+	// String would return a blank rest as an empty string, without the text
+	// before it; its whitespace is handled as usual instead, e.g. trailing
+	// whitespace is elided.
+	c.setLexer([]byte(rest), nil)
+	b, err := c.process(len(rest))
+	if err != nil {
+		return "", err
+	}
+	return string(c.endLines(b)), nil
 }
 
-// IndentText sets the value that should be used to indent wrapped lines.
-func (w *Wrapper) IndentText(s string) {
-	// always reset the indent len
-	w.indentLen = 0
-	if s == "" { // no indent
-		w.indentText = nil
-		return
+// wordStart returns the offset of the start of the word, in s, that offset is
+// within, i.e. of the text token that has text on either side of offset;
+// otherwise offset is returned.
+func (w *Wrapper) wordStart(s string, offset int) int {
+	bol := strings.LastIndexByte(s[:offset], nl) + 1
+	w.setLexer([]byte(s[bol:]), nil)
+	defer w.lexer.drain()
+	for {
+		t := w.lexer.nextToken()
+		if t.typ == tokenEOF || t.typ == tokenError {
+			return offset
+		}
+		start := bol + int(t.pos)
+		if start+len(t.value) <= offset {
+			continue
+		}
+		if t.typ == tokenText && start < offset {
+			return start
+		}
+		return offset
 	}
-	w.indentText = []byte(s)
-	w.setIndentLen()
 }
 
-// sets the indentLen based on indentText and tabsize.
-func (w *Wrapper) setIndentLen() {
-	// calculate the indentLen
-	for _, v := range w.indentText {
-		if v == tab {
-			w.indentLen += w.tabSize
+// Fits returns whether s fits on a single line, i.e. whether it would not be
+// wrapped: s has no new lines and its width, plus that of any comment prefix
+// or label the line starts with, fits in Length; see FillExact. s is measured
+// a rune at a time, stopping at the first new line or as soon as it no longer
+// fits, so a long s isn't tokenized, or even fully measured.
+func (w *Wrapper) Fits(s string) bool {
+	c := w.config()
+	c.commentBegin()
+	o := c.lexOptions()
+	c.l += o.measure(string(c.label))
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case nl:
+			return false
+		case cr: // elided
+			i++
 			continue
 		}
-		w.indentLen++
+		n := 0
+		if o.ignoreANSI && s[i] == esc {
+			n = ansiLen(s[i:])
+		}
+		if n == 0 {
+			_, n = utf8.DecodeRuneInString(s[i:])
+		}
+		c.l += o.measure(s[i : i+n])
+		if !c.fits(0) {
+			return false
+		}
+		i += n
 	}
+	return true
 }
 
-// wrap figures out wrapping of line stuff
-func (w *Wrapper) wrap(t *token) (skip bool) {
-	if t.typ == tokenTab {
-		t.len = w.tabSize
+// NeedsWrap returns whether wrapping s would change it: either a line of s
+// doesn't fit, see Fits, or the Wrapper's configuration would change the
+// text, e.g. its CommentStyle would make it a comment or whitespace at the end
+// of a line would be elided.
+func (w *Wrapper) NeedsWrap(s string) bool {
+	if w.CommentStyle != NoComment || len(w.label) > 0 || len(w.suffix) > 0 || w.noBreakOpen != 0 ||
+		w.SentencePerLine || w.RespectMarkdownBreaks || w.transformer != nil || w.PadToWidth || w.EmailQuotes {
+		return true
 	}
-	if w.l+t.len < w.Length { // if a new line isn't going to be emitted, return
-		return
+	// the lines after the first are indented, and they end with the line
+	// ending.
+	if (len(w.indentText) > 0 || w.AutoIndent || w.newline != nil) && strings.IndexByte(s, nl) >= 0 {
+		return true
 	}
-	w.nl()
-	if isSpace(t.typ) { // if this token is a space or spaces, it should be skipped
+	if w.StripZeroWidthSpace && strings.Contains(s, zeroWidthSpace) {
 		return true
 	}
-	return false
-}
-
-func (w *Wrapper) commentBegin() {
-	switch w.CommentStyle {
-	case NoComment:
-		return
-	case CPPComment, ShellComment:
-		w.lineComment()
-	case CComment:
-		w.b = append(w.b, cCommentBegin...)
+	if w.SoftHyphenOnly && strings.Contains(s, "\u00AD") { // the ones that aren't used are elided
+		return true
+	}
+	if w.locale == "fr" && frenchSpacing(s) != s {
+		return true
+	}
+	if strings.IndexByte(s, cr) >= 0 { // it's elided
+		return true
+	}
+	lines := strings.Split(s, "\n")
+	if w.MaxLines > 0 && len(lines) > w.MaxLines {
+		return true
+	}
+	var blanks int
+	for i, line := range lines {
+		if !w.Fits(line) {
+			return true
+		}
+		if line == "" {
+			if blanks++; w.MaxBlankLines > 0 && blanks > w.MaxBlankLines && i < len(lines)-1 {
+				return true
+			}
+			continue
+		}
+		blanks = 0
+		if strings.TrimRightFunc(line, isTrailingSpace) != line {
+			return true
+		}
+		// only whitespace after a new line in the input is leading whitespace
+		if i == 0 || w.LeadingSpace == KeepLeadingSpace {
+			continue
+		}
+		lead := line[:len(line)-len(strings.TrimLeftFunc(line, unicode.IsSpace))]
+		if lead != "" && (w.LeadingSpace == ElideLeadingSpace || lead != " ") {
+			return true
+		}
+	}
+	return false
+}
+
+// WrapIfNeeded returns s as is if it doesn't need to be wrapped, see
+// NeedsWrap, otherwise it returns s wrapped.
+func (w *Wrapper) WrapIfNeeded(s string) (string, error) {
+	if !w.NeedsWrap(s) {
+		return s, nil
+	}
+	return w.String(s)
+}
+
+// Delimited returns whether the output of the last call to String, Bytes, or
+// Runes is enclosed in the CComment begin and end delimiters, "/*" and "*/".
+// They are either both in the output or neither is: blank input, and input
+// whose wrapping results in an error, don't have either.
+func (w *Wrapper) Delimited() bool {
+	return w.delimited
+}
+
+// ElidedSpaces returns the number of whitespace chars that were elided from
+// the output since the last Reset: the trailing whitespace removed from the
+// end of lines, the whitespace dropped from the start of wrapped lines, and,
+// with ElideLeadingSpace, the leading whitespace of the input's lines. It
+// accounts for output that is shorter than its input.
+func (w *Wrapper) ElidedSpaces() int {
+	return w.elided
+}
+
+// LastColumn returns the width, in columns, of the last line of the wrapped
+// output, i.e. the column that the output ends at, e.g. so that more text can
+// be appended to the last line. It is only valid after String, Bytes, or
+// Runes; after Reset, it is 0.
+func (w *Wrapper) LastColumn() int {
+	return w.lexOptions().measure(string(w.b[w.lineStart():]))
+}
+
+// Ellipsis sets the string that the last line of truncated output ends with;
+// see MaxLines. The default is Ellipsis, "…". If s is empty, there is no
+// ellipsis.
+func (w *Wrapper) Ellipsis(s string) {
+	if s == "" {
+		w.ellipsis = nil
+		return
+	}
+	w.ellipsis = []byte(s)
+}
+
+// truncate truncates the output after line MaxLines. If ellipsis, the
+// ellipsis is appended to the last line; the line's trailing text is removed,
+// as needed, to make room for it.
+func (w *Wrapper) truncate(ellipsis bool) {
+	defer w.resetSGR()
+	w.truncated = w.truncated || ellipsis
+	w.b = w.b[:w.cut]
+	w.bol = w.cutBOL
+	w.lines = w.MaxLines
+	w.l = w.lexOptions().measure(string(w.b[w.lineStart():]))
+	w.priorToken = token{}
+	if !ellipsis || len(w.ellipsis) == 0 {
+		return
+	}
+	n := w.lexOptions().measure(string(w.ellipsis))
+	for !w.fits(n) && len(w.b) > w.bol {
+		// remove the last word; if it's the only one, remove its last char.
+		i := bytes.LastIndexFunc(w.b[w.bol:], unicode.IsSpace)
+		if i < 0 {
+			line := w.b[w.bol:]
+			_, size := utf8.DecodeLastRune(line)
+			// an escape sequence is removed whole; see IgnoreANSI.
+			if j := bytes.LastIndexByte(line, esc); j >= 0 && ansiLen(string(line[j:])) == len(line)-j {
+				size = len(line) - j
+			}
+			w.b = w.b[:len(w.b)-size]
+		} else {
+			w.b = append(w.b[:w.bol], bytes.TrimRightFunc(w.b[w.bol:w.bol+i], unicode.IsSpace)...)
+		}
+		w.l = w.lexOptions().measure(string(w.b[w.lineStart():]))
+	}
+	w.b = append(w.b, w.ellipsis...)
+	w.l += n
+}
+
+// resetSGR ends the truncated output with an SGR reset if an SGR sequence is
+// in effect at its end, as the reset that followed it in the input was cut;
+// otherwise the color, or other attribute, would carry over to whatever is
+// written after the output. See IgnoreANSI.
+func (w *Wrapper) resetSGR() {
+	if !w.IgnoreANSI {
+		return
+	}
+	w.sgr = w.sgr[:0]
+	w.updateSGR(string(w.b))
+	if len(w.sgr) > 0 {
+		w.b = append(w.b, sgrReset...)
+		w.sgr = w.sgr[:0]
+	}
+}
+
+// WrapToWidth returns a wrapped string whose lines are width characters, or
+// less, in length. The width only applies to this call; w's Length is not
+// changed.
+func (w *Wrapper) WrapToWidth(s string, width int) (string, error) {
+	c := w.config()
+	c.Length = width
+	return c.String(s)
+}
+
+// FitBox returns s wrapped to fit in a box that is cols chars wide and rows
+// lines high, e.g. a viewport in a terminal UI: lines can be cols chars long,
+// see FillExact, and, if there are more than rows lines, the text is truncated
+// and the last line ends with the ellipsis; see MaxLines. overflow is whether
+// s didn't fit: either it was truncated or a line is wider than cols, e.g.
+// because a word is. If rows is 0, there is no max. The box only applies to
+// this call; w's configuration is not changed.
+func (w *Wrapper) FitBox(s string, cols, rows int) (fitted string, overflow bool, err error) {
+	c := w.config()
+	c.Length = cols
+	c.MaxLines = rows
+	c.FillExact = true
+	c.newline = nil
+	fitted, err = c.String(s)
+	if err != nil {
+		return fitted, false, err
+	}
+	overflow = c.truncated
+	o := c.lexOptions()
+	for _, line := range strings.Split(fitted, "\n") {
+		if o.measure(line) > cols {
+			overflow = true
+		}
+	}
+	return string(w.endLines([]byte(fitted))), overflow, nil
+}
+
+// WrapMaxBytes returns s wrapped, in at most max bytes, e.g. for a fixed
+// size database column or packet payload. If the wrapped text is longer than
+// max bytes, it is cut at the end of the last line that fits, without that
+// line's new line, and truncated is true; if not even the first line fits,
+// wrapped is empty. The size includes the line endings; see Newline. For a
+// CComment, room is kept for the comment end, which the cut text always ends
+// with; if max can't hold both the comment begin and end, an error is
+// returned. w is not changed.
+func (w *Wrapper) WrapMaxBytes(s string, max int) (wrapped string, truncated bool, err error) {
+	c := w.config()
+	wrapped, err = c.String(s)
+	if err != nil || len(wrapped) <= max {
+		return wrapped, false, err
+	}
+	end := c.lineEnding()
+	// the comment end's line follows the last line that fits.
+	var closing string
+	if c.CommentStyle == CComment && c.delimited {
+		closing = end + strings.TrimSuffix(string(cCommentEnd), "\n") + end
+		if n := len(cCommentBegin) - 1 + len(closing); max < n {
+			return "", false, fmt.Errorf("max %d bytes can't hold the comment delimiters: %d bytes are needed", max, n)
+		}
+		wrapped = strings.TrimSuffix(wrapped, closing)
+		max -= len(closing)
+	}
+	var cut int
+	for i := 0; ; {
+		j := strings.Index(wrapped[i:], end)
+		if j < 0 || i+j > max {
+			break
+		}
+		cut = i + j
+		i = cut + len(end)
+	}
+	return wrapped[:cut] + closing, true, nil
+}
+
+// Split splits s into messages that are each no more than limit wide, in
+// the LengthUnit, e.g. Bytes for IRC or Runes for SMS, so that a long message
+// can be sent as several, e.g. for chat. The messages don't have new lines;
+// each new line in s is a space. They are broken at whitespace, which is
+// elided, and dashes, like lines are; a word that is wider than limit is
+// broken wherever it has to be, see BreakLongWords. If SplitIndicator is set,
+// each message ends with its indicator; if limit doesn't leave room for any
+// text after it, there are no messages. w's configuration is used for how the
+// text is measured and broken; the layout of lines, e.g. the CommentStyle,
+// indent, and label, isn't. If s is blank, there are no messages.
+func (w *Wrapper) Split(s string, limit int) []string {
+	s = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+	if isBlank(s) || limit < 1 {
+		return nil
+	}
+	c := w.config()
+	c.CommentStyle = NoComment
+	c.IndentText("")
+	c.label, c.suffix, c.softBreak, c.newline = nil, nil, nil, nil
+	c.FillExact = true
+	c.BreakLongWords = true
+	c.MaxLines = 0
+	c.PadToWidth = false
+	msgs := c.split(s, limit)
+	if !w.SplitIndicator || len(msgs) < 2 {
+		return msgs
+	}
+	// the indicator's width depends on the number of messages, which depends
+	// on the width that's left for the text.
+	for digits := 1; ; digits++ {
+		n := strings.Repeat("9", digits)
+		width := c.lexOptions().measure(" (" + n + "/" + n + ")")
+		if width >= limit {
+			return nil
+		}
+		msgs = c.split(s, limit-width)
+		if len(strconv.Itoa(len(msgs))) <= digits {
+			break
+		}
+	}
+	for i := range msgs {
+		msgs[i] += fmt.Sprintf(" (%d/%d)", i+1, len(msgs))
+	}
+	return msgs
+}
+
+// split wraps s, which doesn't have any new lines, to lines no more than
+// limit wide and returns them.
+func (w *Wrapper) split(s string, limit int) []string {
+	w.Length = limit
+	w.Reset()
+	wrapped, err := w.String(s)
+	if err != nil || wrapped == "" {
+		return nil
+	}
+	return strings.Split(wrapped, "\n")
+}
+
+// Analyze returns every point at which s can be broken, in order, with the
+// width of the line if it's broken there, e.g. for a layout optimizer that
+// chooses the breaks itself. The break points and the widths are what the
+// Wrapper uses: at whitespace, the line ends before it, as the whitespace is
+// elided, and at a dash, or other text a break can occur after, e.g. a
+// slash, see BreakAfterSlash, the line ends after it. A new line in s is a
+// break that must be taken: the widths of the break points after it are from
+// its end. The widths are of the text only, without any prefix or indent, and
+// don't include trailing whitespace. Whitespace at the start of an input line
+// is elided: it isn't a break point and it isn't counted.
+func (w *Wrapper) Analyze(s string) []BreakCandidate {
+	l := newLexer([]byte(s), nil, w.lexOptions())
+	var (
+		cs    []BreakCandidate
+		width int  // the width of the input line, up to the end of its last text
+		space int  // the width of the whitespace after the line's last text
+		text  bool // whether there is text, not just whitespace, on the input line
+	)
+	for {
+		t := l.nextToken()
+		switch t.typ {
+		case tokenEOF, tokenError:
+			return cs
+		case tokenCR:
+			continue
+		case tokenNL:
+			cs = append(cs, BreakCandidate{Offset: int(t.pos), Width: width, Kind: NewlineToken})
+			width, space, text = 0, 0, false
+			continue
+		case tokenWhitespace:
+			if text {
+				cs = append(cs, BreakCandidate{Offset: int(t.pos), Width: width, Kind: t.kind()})
+				space += t.len
+			}
+			continue
+		}
+		width += space + t.len
+		space = 0
+		text = true
+		if t.typ == tokenHyphen || t.typ == tokenBreakAfter {
+			cs = append(cs, BreakCandidate{Offset: int(t.pos) + len(t.value), Width: width, Kind: t.kind()})
+		}
+	}
+}
+
+// OutputSize returns the size, in bytes, that s would have once wrapped,
+// including the comment prefixes, indents, and line endings, e.g. to size a
+// buffer for it. The wrapping is done, but the output isn't kept: each line
+// is counted once it can no longer change. w is not changed.
+func (w *Wrapper) OutputSize(s string) (int, error) {
+	c := w.config()
+	c.newline = nil
+	c.count = true
+	b, err := c.Bytes([]byte(s))
+	if err != nil {
+		return 0, err
+	}
+	n := c.countedNL + bytes.Count(b, []byte{nl})
+	return c.counted + len(b) + n*(len(w.lineEnding())-1), nil
+}
+
+// ReflowOn wraps src each time a width is received on widths, e.g. each time
+// a terminal UI is resized, and sends the wrapped text on the returned
+// channel; see WrapToWidth. The channel is closed after widths is closed. w's
+// configuration, as of the call, is used; changes to w after the call don't
+// affect the reflowed text. If src can't be wrapped to a width, e.g. it is
+// too narrow for StrictWidth, nothing is sent for it.
+func (w *Wrapper) ReflowOn(src string, widths <-chan int) <-chan string {
+	c := w.Clone()
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for width := range widths {
+			s, err := c.WrapToWidth(src, width)
+			if err != nil {
+				continue
+			}
+			out <- s
+		}
+	}()
+	return out
+}
+
+// LineReader returns a reader of src wrapped, e.g. for a program that
+// processes the wrapped text line by line from a pipe. Each ReadString('\n')
+// returns exactly one wrapped line, with its new line; the last line may not
+// have one, in which case it is returned with io.EOF. All of src is read, and
+// wrapped, before LineReader returns. If src can't be read or wrapped, the
+// error is returned by the reader instead of the wrapped text.
+func (w *Wrapper) LineReader(src io.Reader) *bufio.Reader {
+	in, err := io.ReadAll(src)
+	if err != nil {
+		return bufio.NewReader(errReader{err})
+	}
+	b, err := w.config().Bytes(in)
+	if err != nil {
+		return bufio.NewReader(errReader{err})
+	}
+	return bufio.NewReader(bytes.NewReader(b))
+}
+
+// errReader is an io.Reader whose reads only return err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// StringComment returns a wrapped string formatted as a comment of the given
+// style. The style only applies to this call; w's CommentStyle is not changed.
+func (w *Wrapper) StringComment(s string, style CommentStyle) (string, error) {
+	c := w.config()
+	c.CommentStyle = style
+	return c.String(s)
+}
+
+// WrapTransform returns a wrapped string; t is applied to text before it is
+// measured, e.g. so that combining characters are composed before the width
+// of the text is determined. Whitespace and dashes are not transformed.
+func (w *Wrapper) WrapTransform(s string, t Transformer) (string, error) {
+	c := w.config()
+	c.transformer = t
+	return c.String(s)
+}
+
+// transform applies the transformer to the token's value and updates its len.
+func (w *Wrapper) transform(t *token) error {
+	w.transformer.Reset()
+	src := []byte(t.value)
+	dst := make([]byte, len(src)+utf8.UTFMax)
+	var n int
+	for {
+		nDst, nSrc, err := w.transformer.Transform(dst[n:], src, true)
+		n += nDst
+		src = src[nSrc:]
+		if err == nil {
+			break
+		}
+		// if progress was made, try again; otherwise dst may be too short.
+		if nDst > 0 || nSrc > 0 {
+			continue
+		}
+		if len(dst)-n > 4*len(src)+utf8.UTFMax { // it wasn't the dst
+			return err
+		}
+		dst = append(dst, make([]byte, len(dst))...)
+	}
+	t.value = string(dst[:n])
+	t.len = w.lexOptions().measure(t.value)
+	return nil
+}
+
+// config returns a Wrapper with w's configuration and none of its state, so
+// that it can be used for a single call without affecting w.
+func (w *Wrapper) config() *Wrapper {
+	c := *w
+	c.b = nil
+	c.sgr = nil
+	c.spaces = nil
+	c.prevSpaces = nil
+	c.lexer = nil // the lexer can't be shared
+	c.Reset()
+	return &c
+}
+
+// Clone returns a new Wrapper with a copy of w's configuration, e.g. so that
+// a base configuration can be used for variants with a different Length or
+// CommentStyle. None of w's state is copied and the copy doesn't share any
+// memory with w, so changing either doesn't affect the other. Funcs, e.g. the
+// OnToken func, are shared.
+func (w *Wrapper) Clone() *Wrapper {
+	c := w.config()
+	c.indentText = append([]byte(nil), w.indentText...)
+	c.blockPrefix = append([]byte(nil), w.blockPrefix...)
+	c.label = append([]byte(nil), w.label...)
+	c.suffix = append([]byte(nil), w.suffix...)
+	c.softBreak = append([]byte(nil), w.softBreak...)
+	c.newline = append([]byte(nil), w.newline...)
+	c.ellipsis = append([]byte(nil), w.ellipsis...)
+	c.commentSep = append([]byte(nil), w.commentSep...)
+	c.boxCorners = append([]rune(nil), w.boxCorners...)
+	if w.DashBreaks != nil {
+		c.DashBreaks = make(map[rune]BreakDirection, len(w.DashBreaks))
+		for r, d := range w.DashBreaks {
+			c.DashBreaks[r] = d
+		}
+	}
+	return c
+}
+
+// OnToken sets a func that is called for every token processed while
+// wrapping, e.g. to count words. It does not affect the wrapping. If fn is
+// nil, no func will be called.
+func (w *Wrapper) OnToken(fn func(kind TokenKind, value string)) {
+	w.onToken = fn
+}
+
+// NoBreakDelimiters sets the runes that delimit regions of text that won't be
+// broken; the text within the delimiters is treated as a single unbreakable
+// unit and the delimiters are elided from the output. This is similar to
+// joining text with zero width no-break spaces, U+FEFF. Within a region, new
+// lines are retained. If open is 0, there are no regions.
+func (w *Wrapper) NoBreakDelimiters(open, close rune) {
+	w.noBreakOpen = open
+	w.noBreakClose = close
+}
+
+// NoBreakJoiner sets the rune that joins text so that no break occurs on
+// either side of it, e.g. "5\uFEFF kg" won't be broken at the space. The
+// default is the zero width no-break space, U+FEFF. If the input contains
+// U+FEFF as data, e.g. a byte order mark, another rune can be used instead;
+// U+FEFF is then like any other text. If r is 0, there is no joiner. The
+// word joiner, U+2060, which replaces U+FEFF as a joiner, is always one.
+func (w *Wrapper) NoBreakJoiner(r rune) {
+	w.joiner = r
+}
+
+// Locale sets the locale, a BCP 47 language tag, e.g. "fr" or "fr-CA", whose
+// line breaking rules are followed, in addition to the Wrapper's. Only the
+// language matters. For French, "fr", a narrow no-break space, U+202F, is
+// kept before each high punctuation mark, ';', ':', '!', and '?', that ends a
+// word, e.g. "Vraiment !", so that no break will occur before it: the
+// whitespace before the mark is replaced by it or, if there isn't any, it is
+// inserted. Other languages have no additional rules. The default, "", is
+// no locale.
+func (w *Wrapper) Locale(tag string) {
+	lang := strings.ToLower(tag)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	w.locale = lang
+}
+
+// frenchSpacing returns s with a narrow no-break space before each high
+// punctuation mark that ends a word; see Locale. A mark that is followed by a
+// letter, a digit, or a slash, e.g. in "10:30" or "https://", doesn't end a
+// word.
+func frenchSpacing(s string) string {
+	isSpace := func(r rune) bool { return r == ' ' || r == tab || r == '\u00A0' || r == '\u202F' }
+	isHigh := func(r rune) bool { return strings.ContainsRune(";:!?", r) }
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		next, _ := utf8.DecodeRuneInString(s[i+n:])
+		if isHigh(r) && !unicode.IsLetter(next) && !unicode.IsDigit(next) && next != '/' {
+			word := bytes.TrimRightFunc(b, isSpace)
+			if last, _ := utf8.DecodeLastRune(word); len(word) > 0 && !unicode.IsSpace(last) && !isHigh(last) {
+				b = append(word, "\u202F"...)
+			}
+		}
+		b = append(b, s[i:i+n]...)
+		i += n
+	}
+	return string(b)
+}
+
+// FillChar sets the rune that lines are padded with, see PadToWidth, e.g. '.'
+// for the leader dots of a table of contents. The default is a space. If the
+// width of r doesn't evenly divide the padding, the rest of it is spaces.
+func (w *Wrapper) FillChar(r rune) {
+	w.fill = r
+}
+
+// BreakAfter sets the chars, in addition to whitespace and dashes, that a line
+// can be broken after, e.g. ",;" to break a long list whose items aren't
+// separated by spaces. A char that is between two digits is part of a number,
+// e.g. the ',' in "1,000,000" or the '.' in "3.14", so no break will occur at
+// it. If chars is empty, which is the default, there are no other chars.
+func (w *Wrapper) BreakAfter(chars string) {
+	w.breakAfter = chars
+}
+
+// abbreviations are the abbreviations that KeepAbbreviations keeps with the
+// word that follows them.
+var abbreviations = []string{"e.g.", "i.e.", "etc.", "vs."}
+
+// KeepTogether sets a func that is consulted at each space that the line
+// could be broken at; left and right are the text on either side of the
+// space. If fn returns true, the space is treated as non-breaking, e.g. to
+// keep a number with its unit, as in "5 kg". If fn is nil, which is the
+// default, the line may be broken at any space.
+func (w *Wrapper) KeepTogether(fn func(left, right string) bool) {
+	w.keepTogether = fn
+}
+
+// BreakDecider sets a func that decides, for each token that isn't a new line,
+// whether to break the line before it, instead of the line being broken when
+// the token doesn't fit, e.g. for a wrapping policy that depends on the text.
+// A line is never broken before its first token, even if fn returns true; a
+// space that a line is broken before is elided. If fn is nil, which is the
+// default, lines are wrapped to Length.
+func (w *Wrapper) BreakDecider(fn func(ctx BreakContext) bool) {
+	w.breakDecider = fn
+}
+
+// SoftBreakMarker sets the marker that ends each line that was broken by the
+// wrapper, a soft break, so that soft breaks can be told apart from the new
+// lines that were in the input, hard breaks, e.g. to unwrap the text and wrap
+// it again. The marker, e.g. a trailing space or a zero width space, is
+// written before the soft break's new line; it isn't counted against Length.
+// If marker is empty, soft breaks aren't marked, which is the default.
+func (w *Wrapper) SoftBreakMarker(marker string) {
+	if marker == "" {
+		w.softBreak = nil
+		return
+	}
+	w.softBreak = []byte(marker)
+}
+
+// Newline sets the line ending of the output, e.g. "\r\n" for CRLF line
+// endings, or "\r" for a terminal UI that overwrites a single status line in
+// place. Each new line, '\n', of the wrapped output is replaced by s after the
+// text is wrapped, so trailing whitespace is elided before it as usual. If s
+// is empty, the line ending is "\n", which is the default.
+func (w *Wrapper) Newline(s string) {
+	if s == "" || s == "\n" {
+		w.newline = nil
+		return
+	}
+	w.newline = []byte(s)
+}
+
+// lineEnding returns the line ending of the output; see Newline.
+func (w *Wrapper) lineEnding() string {
+	if w.newline == nil {
+		return "\n"
+	}
+	return string(w.newline)
+}
+
+// endLines returns b with its new lines replaced by the line ending, if it
+// isn't "\n"; see Newline.
+func (w *Wrapper) endLines(b []byte) []byte {
+	if w.newline == nil {
+		return b
+	}
+	return bytes.Replace(b, []byte{nl}, w.newline, -1)
+}
+
+// Unwrap returns s with its soft breaks, as marked by the SoftBreakMarker,
+// removed: each soft break, along with the prefix, e.g. indent or comment
+// prefix, that starts the line following it, is replaced by a space, unless
+// the line it ends was broken after a hyphen. Hard breaks are left as is. If
+// w has no SoftBreakMarker, s is returned unchanged.
+func (w *Wrapper) Unwrap(s string) string {
+	if w.softBreak == nil {
+		return s
+	}
+	lines := strings.Split(s, string(w.softBreak)+w.lineEnding())
+	prefix := string(w.linePrefix())
+	b := make([]byte, 0, len(s))
+	for i, line := range lines {
+		if i > 0 {
+			line = strings.TrimPrefix(line, prefix)
+			if !endsWithHyphen(lines[i-1]) {
+				b = append(b, ' ')
+			}
+		}
+		b = append(b, line...)
+	}
+	return string(b)
+}
+
+// Unwrap joins the lines of each paragraph in s into a single line, e.g. so
+// that hard wrapped text can be wrapped to a different Length. Paragraphs are
+// separated by blank lines, which are kept. The new line between two lines is
+// replaced by a space, along with any whitespace around it, unless the first
+// line ends with a hyphen; then the lines are joined without a space.
+//
+// If dehyphenate, a hyphen that was likely inserted to break a word across
+// lines is removed, joining the halves of the word; see isInsertedHyphen.
+func Unwrap(s string, dehyphenate bool) string {
+	lines := strings.Split(s, "\n")
+	b := make([]byte, 0, len(s))
+	var prev string // the prior line of the current paragraph; empty at a paragraph's start
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" { // a paragraph break
+			if i > 0 {
+				b = append(b, nl)
+			}
+			prev = ""
+			continue
+		}
+		if prev == "" {
+			if i > 0 {
+				b = append(b, nl)
+			}
+			prev = strings.TrimRightFunc(line, unicode.IsSpace)
+			b = append(b, prev...)
+			continue
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case dehyphenate && isInsertedHyphen(s, prev, line):
+			_, n := utf8.DecodeLastRuneInString(prev)
+			b = b[:len(b)-n]
+		case !endsWithHyphen(prev):
+			b = append(b, ' ')
+		}
+		prev = line
+		b = append(b, prev...)
+	}
+	return string(b)
+}
+
+// isInsertedHyphen returns whether the hyphen that ends line was likely
+// inserted to break a word across it and next, as opposed to being part of a
+// hyphenated compound word, e.g. "well-known". A soft hyphen is always an
+// inserted hyphen. Otherwise, the hyphen must be between lowercase letters
+// and the hyphenated word can't be found elsewhere in s.
+func isInsertedHyphen(s, line, next string) bool {
+	r, n := utf8.DecodeLastRuneInString(line)
+	switch r {
+	case '\u00AD':
+		return true
+	case '-', '\u2010':
+	default:
+		return false
+	}
+	left := line[:len(line)-n]
+	before, _ := utf8.DecodeLastRuneInString(left)
+	after, _ := utf8.DecodeRuneInString(next)
+	if !unicode.IsLower(before) || !unicode.IsLower(after) {
+		return false
+	}
+	if i := strings.LastIndexFunc(left, unicode.IsSpace); i >= 0 {
+		left = left[i+1:]
+	}
+	right := next
+	if i := strings.IndexFunc(right, unicode.IsSpace); i >= 0 {
+		right = right[:i]
+	}
+	right = strings.TrimRightFunc(right, func(r rune) bool { return !unicode.IsLetter(r) })
+	return !strings.Contains(s, left+string(r)+right)
+}
+
+// WrapBoth returns s wrapped as it is by String, commented, and the same
+// wrapped text without the comment, plain, e.g. for both a file header and a
+// changelog entry. The text is only wrapped once, so the lines of both are
+// broken at the same places: plain is commented with the comment delimiters
+// and the prefix of each line, e.g. "// ", removed. For CComment, the
+// indent, which is part of the prefix, is also removed. w is not changed.
+func (w *Wrapper) WrapBoth(s string) (plain, commented string, err error) {
+	c := w.config()
+	c.newline = nil
+	commented, err = c.String(s)
+	if err != nil {
+		return "", "", err
+	}
+	plain = commented
+	if c.CommentStyle != NoComment {
+		plain = c.uncomment(commented)
+	}
+	return string(w.endLines([]byte(plain))), string(w.endLines([]byte(commented))), nil
+}
+
+// uncomment returns s, which was wrapped by w, with the comment delimiters
+// and the prefix of each line removed. A blank comment line, whose prefix
+// has had its trailing whitespace elided, is empty.
+func (w *Wrapper) uncomment(s string) string {
+	if w.CommentStyle == CComment {
+		s = strings.TrimPrefix(s, string(cCommentBegin))
+		s = strings.TrimSuffix(s, string(cCommentEnd))
+		s = strings.TrimSuffix(s, "\n")
+	}
+	prefix := string(w.linePrefix())
+	blank := strings.TrimRightFunc(prefix, unicode.IsSpace)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line == blank {
+			lines[i] = ""
+			continue
+		}
+		lines[i] = strings.TrimPrefix(line, prefix)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReflowComment returns the line comment s wrapped again, e.g. after it was
+// edited, as a comment of w's CommentStyle. Each line's comment marker, e.g.
+// "//", "#", "--", ";;", or a batch file's "REM", is removed before the text is
+// unwrapped, see Unwrap, and wrapped, so the markers don't need to be
+// consistent: they are matched case insensitively and can differ from line to
+// line. Every line of the output has the CommentStyle's marker. Lines without
+// a marker are kept as text. w is not changed.
+func (w *Wrapper) ReflowComment(s string) (string, error) {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = stripCommentMarker(line)
+	}
+	return w.config().String(Unwrap(strings.Join(lines, "\n"), false))
+}
+
+// stripCommentMarker returns line without the comment marker, and the
+// whitespace around it, that it starts with, if it starts with one; see
+// commentMarkers. A word marker, e.g. "rem", must be followed by whitespace
+// or the end of the line.
+func stripCommentMarker(line string) string {
+	s := strings.TrimLeftFunc(line, unicode.IsSpace)
+	for _, m := range commentMarkers {
+		if len(s) < len(m) || !strings.EqualFold(s[:len(m)], m) {
+			continue
+		}
+		rest := s[len(m):]
+		if r, _ := utf8.DecodeRuneInString(rest); isWordMarker(m) && unicode.IsLetter(r) {
+			continue
+		}
+		return strings.TrimSpace(rest)
+	}
+	return line
+}
+
+// isWordMarker returns whether the comment marker m is a word, e.g. "rem".
+func isWordMarker(m string) bool {
+	r, _ := utf8.DecodeLastRuneInString(m)
+	return unicode.IsLetter(r)
+}
+
+// linePrefix returns what a new line starts with: the comment prefix for line
+// comments, otherwise the indent text followed by the block line prefix, for
+// CComment.
+func (w *Wrapper) linePrefix() []byte {
+	if p := w.lineCommentPrefix(); p != nil {
+		return p
+	}
+	if w.CommentStyle == CComment {
+		return append(append([]byte(nil), w.indentText...), w.blockPrefix...)
+	}
+	return w.indentText
+}
+
+// endsWithHyphen returns whether s ends with a hyphen that a line can be
+// broken after.
+func endsWithHyphen(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	t, ok := key[string(r)]
+	return ok && isHyphen(t)
+}
+
+// AlignUnder sets the label that the first line starts with; the wrapped
+// lines are indented with spaces so that they are aligned under the text
+// following the label, e.g. for "Description: " the wrapped lines are indented
+// by 13 spaces. For line comments, the label and the indent follow the
+// comment prefix. This replaces any indentText. If label is empty, there is
+// no label or indent.
+func (w *Wrapper) AlignUnder(label string) {
+	if label == "" {
+		w.label = nil
+		w.IndentText("")
+		return
+	}
+	w.label = []byte(label)
+	w.IndentText(strings.Repeat(" ", w.lexOptions().measure(label)))
+}
+
+// WrapCSmart returns s as a C style comment: if s fits on a single line, it
+// is a single line CComment, e.g. "/* s */", otherwise it is wrapped as
+// CPPComment line comments or, if CSmartBlock, a CComment block. The
+// Wrapper's CommentStyle is ignored; w is not changed.
+func (w *Wrapper) WrapCSmart(s string) (string, error) {
+	if isBlank(s) { // if the string is empty, or blank, no comment
+		return "", nil
+	}
+	c := w.config()
+	text := strings.TrimSpace(s)
+	if !strings.ContainsAny(text, "\r\n") {
+		n := c.lexOptions().measure(text)
+		if c.LengthIncludesPrefix {
+			n += len(cInlineBegin) + len(cInlineEnd)
+		}
+		if c.FillExact && n <= c.Length || n < c.Length {
+			return string(cInlineBegin) + text + string(cInlineEnd), nil
+		}
+	}
+	c.CommentStyle = CPPComment
+	if c.CSmartBlock {
+		c.CommentStyle = CComment
+	}
+	return c.String(s)
+}
+
+// WrapFieldComment returns code, e.g. a Go struct field, followed by comment
+// as a trailing CPPComment line comment, e.g. "Field int // comment". The
+// comment is wrapped so that its lines, which start at the column of the
+// first line's "//", fit in Length; its continuation lines are aligned under
+// the first "//". The leading whitespace of code, e.g. its indentation, starts
+// the continuation lines too; the rest of the alignment is spaces. The
+// Wrapper's CommentStyle is ignored; w is not changed.
+func (w *Wrapper) WrapFieldComment(code, comment string) (string, error) {
+	if isBlank(comment) {
+		return code, nil
+	}
+	c := w.config()
+	c.CommentStyle = CPPComment
+	c.newline = nil
+	line := code[strings.LastIndexByte(code, nl)+1:]
+	col := c.lexOptions().measure(line) + 1
+	c.Length -= col
+	text, err := c.String(comment)
+	if err != nil {
+		return "", err
+	}
+	lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	pad := lead + strings.Repeat(" ", col-c.lexOptions().measure(lead))
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		if i == 0 {
+			lines[i] = code + " " + lines[i]
+			continue
+		}
+		lines[i] = pad + lines[i]
+	}
+	return string(w.endLines([]byte(strings.Join(lines, "\n")))), nil
+}
+
+// WrapNumbered returns the items as a numbered list: each item starts with
+// its number, e.g. "1. ", and its wrapped lines are aligned under its text;
+// see AlignUnder. The marker's width depends on the number of digits, so
+// items 10 and up are indented one more space than items 1 through 9. Each
+// item is wrapped separately, using w's configuration, and the items are
+// separated by new lines. w is not changed.
+func (w *Wrapper) WrapNumbered(items []string) (string, error) {
+	c := w.config()
+	c.newline = nil
+	var b []byte
+	for i, item := range items {
+		if i > 0 {
+			b = append(b, nl)
+		}
+		c.Reset()
+		c.AlignUnder(fmt.Sprintf("%d. ", i+1))
+		s, err := c.String(item)
+		if err != nil {
+			return "", err
+		}
+		b = append(b, s...)
+	}
+	return string(w.endLines(b)), nil
+}
+
+// WrapParagraphs returns the paragraphs, ps, each wrapped and separated by a
+// blank line, or the ParagraphSeparator. Blank lines and separator lines are
+// formatted per the CommentStyle, e.g. "//" for CPPComment, and all of the
+// paragraphs are in a single CComment block. Any whitespace at the start and
+// end of a paragraph is elided and blank paragraphs are skipped.
+func (w *Wrapper) WrapParagraphs(ps []string) (string, error) {
+	text := make([]string, 0, len(ps))
+	for _, p := range ps {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		text = append(text, p)
+	}
+	return w.String(strings.Join(text, "\n"+w.paraSep+"\n"))
+}
+
+// ParagraphSeparator sets the lines that separate the paragraphs of
+// WrapParagraphs, e.g. "~~~" for a rule or "\n" for two blank lines. By
+// default, it is empty, i.e. a single blank line.
+func (w *Wrapper) ParagraphSeparator(s string) {
+	w.paraSep = s
+}
+
+// PrependHeader returns fileContent with header, wrapped and formatted per
+// w's configuration, e.g. as a license comment, prepended to it. The header is
+// separated from the content by a blank line. If the content starts with a
+// shebang line, e.g. "#!/bin/sh", the header goes after it so that the
+// content is still a runnable script. w is not changed.
+func (w *Wrapper) PrependHeader(fileContent, header string) (string, error) {
+	hdr, err := w.config().String(header)
+	if err != nil {
+		return "", err
+	}
+	if hdr == "" {
+		return fileContent, nil
+	}
+	var shebang string
+	if strings.HasPrefix(fileContent, "#!") {
+		i := strings.IndexByte(fileContent, nl)
+		if i < 0 {
+			i = len(fileContent) - 1
+		}
+		shebang, fileContent = fileContent[:i+1], fileContent[i+1:]
+		if !strings.HasSuffix(shebang, "\n") {
+			shebang += "\n"
+		}
+	}
+	hdr = strings.TrimRight(hdr, "\n") + "\n"
+	if fileContent != "" {
+		hdr += "\n"
+	}
+	return shebang + hdr + fileContent, nil
+}
+
+// WrapBetween returns body wrapped between prefix and suffix: the first line
+// starts with prefix, the wrapped lines are aligned under the start of body,
+// see AlignUnder, and the last line ends with suffix, e.g. for
+// "key = <body>  # note". If suffix doesn't fit on the last line, it is put on
+// a line of its own, without its leading whitespace. w is not changed.
+func (w *Wrapper) WrapBetween(prefix, body, suffix string) (string, error) {
+	c := w.config()
+	c.AlignUnder(prefix)
+	c.suffix = []byte(suffix)
+	return c.String(body)
+}
+
+// appendSuffix appends the suffix to the last line, or to a new line, if it
+// doesn't fit.
+func (w *Wrapper) appendSuffix() {
+	suffix := w.suffix
+	if !w.fits(w.lexOptions().measure(string(suffix))) && len(w.b) > w.bol {
+		w.priorToken = token{}
+		w.softNL()
+		suffix = bytes.TrimLeftFunc(suffix, unicode.IsSpace)
+	}
+	if w.PadToWidth {
+		w.pad(w.lineStart(), w.lineLength()-w.lexOptions().measure(string(suffix)))
+	}
+	w.b = append(w.b, suffix...)
+	w.l += w.lexOptions().measure(string(suffix))
+}
+
+// BoxChars sets the chars used to draw the box of WrapBox, e.g. "+", "-",
+// and "|" for an ASCII box, which is the default, or "┌┐└┘", "─", and "│" for
+// a box drawn with Unicode box drawing chars. corners is either the four
+// corners, in the order top left, top right, bottom left, and bottom right, or
+// a single corner that is used for all four. Each char must be 1 column wide,
+// see RuneWidth, as the edges are repeated to the width of the box; if any of
+// the chars isn't, or an edge isn't a single char, an error is returned and
+// the box chars aren't changed.
+func (w *Wrapper) BoxChars(corners, horizontal, vertical string) error {
+	cs := []rune(corners)
+	if len(cs) == 1 {
+		cs = []rune{cs[0], cs[0], cs[0], cs[0]}
+	}
+	if len(cs) != 4 || !w.isBoxChar(cs...) {
+		return fmt.Errorf("invalid box corners %q: want 1 or 4 chars that are 1 column wide", corners)
+	}
+	for _, edge := range []string{horizontal, vertical} {
+		if rs := []rune(edge); len(rs) != 1 || !w.isBoxChar(rs...) {
+			return fmt.Errorf("invalid box edge %q: want a char that is 1 column wide", edge)
+		}
+	}
+	w.boxCorners = cs
+	w.boxEdge = horizontal
+	w.boxSide = vertical
+	return nil
+}
+
+// isBoxChar returns whether each of rs is 1 column wide; see BoxChars.
+func (w *Wrapper) isBoxChar(rs ...rune) bool {
+	o := w.lexOptions()
+	o.unit = Columns
+	for _, r := range rs {
+		if r == utf8.RuneError || o.measure(string(r)) != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// WrapBox returns s wrapped in a box drawn with the BoxChars. The box is as
+// wide as the widest line of the wrapped text plus the sides of the box and a
+// space of padding on either side, which is reserved from Length. The
+// Wrapper's CommentStyle is ignored; w is not changed.
+func (w *Wrapper) WrapBox(s string) (string, error) {
+	if isBlank(s) { // if the string is empty, or blank, no box
+		return "", nil
+	}
+	c := w.config()
+	c.CommentStyle = NoComment
+	c.newline = nil
+	o := c.lexOptions()
+	side := o.measure(c.boxSide)
+	c.Length -= 2 * (side + 1)
+	text, err := c.String(s)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(text, "\n")
+	var widest int
+	for _, line := range lines {
+		if n := o.measure(line); n > widest {
+			widest = n
+		}
+	}
+	edge := c.boxEdge
+	if n := o.measure(c.boxEdge); n > 0 {
+		edge = strings.Repeat(c.boxEdge, (widest+2)/n)
+	}
+	var b strings.Builder
+	b.WriteString(string(c.boxCorners[0]) + edge + string(c.boxCorners[1]) + "\n")
+	for _, line := range lines {
+		b.WriteString(c.boxSide + " " + line + strings.Repeat(" ", widest-o.measure(line)) + " " + c.boxSide + "\n")
+	}
+	b.WriteString(string(c.boxCorners[2]) + edge + string(c.boxCorners[3]))
+	return string(w.endLines([]byte(b.String()))), nil
+}
+
+// CommentSeparator sets what separates the marker of a line comment, e.g.
+// "//", from the text, e.g. "" for "//text" or " * " for "// * text". By
+// default, it is a space. Blank comment lines are the marker followed by the
+// separator, without any trailing whitespace, e.g. "//" or "// *".
+func (w *Wrapper) CommentSeparator(s string) {
+	w.commentSep = []byte(s)
+}
+
+// BlockLinePrefix sets the prefix for each line within a CComment block, e.g.
+// " * " for javadoc style comments. The prefix follows any indentText.
+func (w *Wrapper) BlockLinePrefix(s string) {
+	if s == "" {
+		w.blockPrefix = nil
+		return
+	}
+	w.blockPrefix = []byte(s)
+}
+
+// Sets the tabsize for line length calculations, when a tab is encountered.
+// Actual tabsize may vary.  See TabSize for the default value. Tabs are the
+// same width wherever they are, e.g. in the indentText and in the text.
+func (w *Wrapper) TabSize(i int) {
+	w.tabSize = i
+	w.setIndentLen() // the indent len may need to be updated
+}
+
+// IndentText sets the value that should be used to indent wrapped lines. For
+// CComment, all lines within the comment block are indented; the comment
+// delimiters are not. A blank line doesn't end with the indent's trailing
+// whitespace, even if TrimTrailing is false, e.g. a blank line is empty if the
+// indent is all whitespace.
+func (w *Wrapper) IndentText(s string) {
+	if s == "" { // no indent
+		w.indentText = nil
+	} else {
+		w.indentText = []byte(s)
 	}
+	w.setIndentLen()
 }
 
-func (w *Wrapper) commentEnd() {
+// Indent returns the text that wrapped lines are indented with and its
+// display width, as used for line length calculations.
+func (w *Wrapper) Indent() (text string, width int) {
+	return string(w.indentText), w.indentLen
+}
+
+// RuneWidth sets the func used to get the display width, in columns, of a
+// rune, e.g. 2 for east asian wide characters. Tabs are always TabSize wide.
+// If fn is nil, each rune is 1 column wide, which is the default.
+func (w *Wrapper) RuneWidth(fn func(r rune) int) {
+	w.runeWidth = fn
+	w.setIndentLen() // the indent len may need to be updated
+}
+
+// sets the indentLen based on indentText, tabsize, and rune widths.
+func (w *Wrapper) setIndentLen() {
+	w.indentLen = w.lexOptions().measure(string(w.indentText))
+}
+
+// wrap figures out wrapping of line stuff
+func (w *Wrapper) wrap(t *token) (skip bool) {
+	if w.breakDecider != nil {
+		return w.decide(t)
+	}
+	// if t must be kept with the text before the space preceding it, that
+	// space isn't a break point: break at the one before it instead.
+	if w.isKeptTogether(t) {
+		w.space = w.prevSpace
+		if !w.fits(t.len) && w.breakAt(w.space) && w.fits(t.len) {
+			return
+		}
+	}
+	if w.fits(t.len) { // if a new line isn't going to be emitted, return
+		return
+	}
+	if w.atLineStart() { // t doesn't fit on any line; a new line won't help
+		return
+	}
+	// a break after a dash may be passed over for the space before it.
+	if w.preferSpace(t) && w.breakAt(w.space) && w.fits(t.len) {
+		return
+	}
+	// if there's a clause break point, break there; t may fit afterwards.
+	if w.ClauseBreaks && w.breakAtClause() && w.fits(t.len) {
+		return
+	}
+	// whether a space is a break point depends on the text that follows it,
+	// so it is held until then.
+	if w.keepsTogether() && isSpace(t.typ) {
+		return
+	}
+	// a space that doesn't fit is skipped; the line is broken before the
+	// token after it, unless that's a new line, which breaks it anyway.
+	if isSpace(t.typ) {
+		w.spaceBreak = true
+		return true
+	}
+	w.softNL()
+	return false
+}
+
+// preferSpace returns whether the line, which t doesn't fit on, should be
+// broken at its last space instead of after the dash that it ends with; see
+// PreferSpaceBreaks.
+func (w *Wrapper) preferSpace(t *token) bool {
+	if !w.PreferSpaceBreaks || isSpace(t.typ) || w.priorToken.typ != tokenHyphen || w.space.pos == 0 {
+		return false
+	}
+	return w.SpaceBreakSlack == 0 || w.l-w.space.l <= w.SpaceBreakSlack
+}
+
+// decide breaks the line before t if the BreakDecider says to; if t is a
+// space, it is skipped.
+func (w *Wrapper) decide(t *token) (skip bool) {
+	ctx := BreakContext{
+		Column:     w.l,
+		Value:      t.value,
+		Kind:       t.kind(),
+		Width:      t.len,
+		Remaining:  w.lineLength() - w.l,
+		PriorValue: w.priorToken.value,
+		PriorKind:  w.priorToken.kind(),
+	}
+	if !w.FillExact {
+		ctx.Remaining--
+	}
+	if !w.breakDecider(ctx) || w.atLineStart() {
+		return false
+	}
+	w.softNL()
+	return isSpace(t.typ)
+}
+
+// tabStops returns the whitespace, s, that starts at the current column with
+// its tabs expanded to the spaces to the next tab stop, and its width; see
+// TabStops.
+func (w *Wrapper) tabStops(s string) (expanded string, width int) {
+	var b strings.Builder
+	col := w.l
+	for _, r := range s {
+		if r != tab {
+			b.WriteRune(r)
+			col += w.lexOptions().measure(string(r))
+			continue
+		}
+		n := w.tabSize - col%w.tabSize
+		b.WriteString(strings.Repeat(" ", n))
+		col += n
+	}
+	return b.String(), col - w.l
+}
+
+// hyphenate handles text, n chars wide, that continues a word after a soft
+// hyphen. If it doesn't fit, the line is broken at the soft hyphen, which is
+// shown as a hyphen. If the hyphen doesn't fit either, the line is broken
+// before the word instead, and the word is hyphenated on the next line, if
+// it still doesn't fit.
+func (w *Wrapper) hyphenate(n int) {
+	if w.fits(n) {
+		return
+	}
+	if !w.fits(1) && (!w.breakAt(w.word) || w.fits(n) || !w.fits(1)) {
+		return
+	}
+	w.b = append(w.b, '-')
+	w.l++
+	w.priorToken = token{}
+	w.softNL()
+}
+
+// breakLongWord breaks the text, t, which doesn't fit on the current line,
+// across lines; each line that it's broken at ends with the ContinuationChar,
+// if there is one. At least one char is written to each line. t is left with
+// the text that fits on the last line.
+func (w *Wrapper) breakLongWord(t *token) {
+	o := w.lexOptions()
+	var cont string
+	if w.ContinuationChar != 0 {
+		cont = string(w.ContinuationChar)
+	}
+	n := o.measure(cont)
+	for !w.fits(t.len) {
+		var i, l int
+		for i < len(t.value) {
+			_, size := utf8.DecodeRuneInString(t.value[i:])
+			rl := o.measure(t.value[i : i+size])
+			if i > 0 && !w.fits(l+rl+n) {
+				break
+			}
+			i += size
+			l += rl
+		}
+		if i == len(t.value) { // the rest of it fits
+			return
+		}
+		w.b = append(w.b, t.value[:i]...)
+		w.b = append(w.b, cont...)
+		w.l += l + n
+		w.priorToken = token{typ: tokenText} // it isn't trailing whitespace
+		w.softNL()
+		t.value = t.value[i:]
+		t.len = o.measure(t.value)
+	}
+}
+
+// widthError returns a WrapError for the token that would make the current
+// line exceed Length.
+func (w *Wrapper) widthError(t token) *WrapError {
+	return &WrapError{
+		Line:      w.countedNL + bytes.Count(w.b, []byte{nl}) + 1,
+		Width:     w.l + t.len,
+		Length:    w.lineLength(),
+		Text:      t.value,
+		MinLength: w.minLength(t),
+	}
+}
+
+// minLength returns the minimum Length that the input can be wrapped to; t is
+// the token that the line couldn't fit. The rest of the input is lexed to
+// find the widest token.
+func (w *Wrapper) minLength(t token) int {
+	widest := w.widest
+	for tkn := t; tkn.typ != tokenEOF && tkn.typ != tokenError; tkn = w.read() {
+		if isSpace(tkn.typ) || tkn.typ == tokenNL {
+			continue
+		}
+		if w.transformer != nil && tkn.typ == tokenText && tkn != t {
+			if w.transform(&tkn) != nil {
+				continue
+			}
+		}
+		if tkn.len > widest {
+			widest = tkn.len
+		}
+	}
+	// lines start with either the label or the indent; line comments aren't
+	// indented, other than under a label.
+	start := w.indentLen
+	if w.lineCommentPrefix() != nil && len(w.label) == 0 {
+		start = 0
+	}
+	if n := w.lexOptions().measure(string(w.label)); n > start {
+		start = n
+	}
+	if w.LengthIncludesPrefix {
+		start += w.commentPrefixLen()
+	}
+	return widest + start
+}
+
+// fits returns whether n more chars fit on the current line. Unless
+// FillExact, a line is wrapped before it reaches Length.
+func (w *Wrapper) fits(n int) bool {
+	if w.FillExact {
+		return w.l+n <= w.lineLength()
+	}
+	return w.l+n < w.lineLength()
+}
+
+// lineLength returns the max length of a line, in chars, taking into account
+// whether or not the comment prefix is included in Length.
+func (w *Wrapper) lineLength() int {
+	if w.LengthIncludesPrefix {
+		return w.Length
+	}
+	return w.Length + w.commentPrefixLen()
+}
+
+// commentPrefixLen returns the length, in chars, of the prefix used for
+// comment lines.
+func (w *Wrapper) commentPrefixLen() int {
 	if w.CommentStyle == CComment {
-		w.b = append(w.b, cCommentEnd...)
+		return w.lexOptions().measure(string(w.blockPrefix))
+	}
+	if m := w.lineCommentMarker(); m != nil {
+		return len(m) + w.lexOptions().measure(string(w.commentSep))
 	}
+	return 0
 }
 
-func (w *Wrapper) lineComment() bool {
+// lineCommentPrefix returns the prefix for line comments, the marker followed
+// by the separator; if the CommentStyle isn't a line comment style, nil is
+// returned.
+func (w *Wrapper) lineCommentPrefix() []byte {
+	m := w.lineCommentMarker()
+	if m == nil {
+		return nil
+	}
+	return append(append([]byte(nil), m...), w.commentSep...)
+}
+
+// lineCommentMarker returns the marker that line comments start with, e.g.
+// "//"; if the CommentStyle isn't a line comment style, nil is returned.
+func (w *Wrapper) lineCommentMarker() []byte {
 	switch w.CommentStyle {
 	case CPPComment:
-		w.cppComment()
-		return true
+		return cppComment
 	case ShellComment:
-		w.shellComment()
+		return shellComment
+	case SQLComment:
+		return sqlComment
+	case LispComment:
+		return lispComment
+	}
+	return nil
+}
+
+// isKeptTogether returns whether t must be kept with the text before the
+// space that precedes it; see KeepTogether.
+func (w *Wrapper) isKeptTogether(t *token) bool {
+	if !w.keepsTogether() || t.typ != tokenText || w.priorToken.typ != tokenWhitespace || w.left == "" {
+		return false
+	}
+	if w.KeepAbbreviations && isAbbreviation(w.left) {
 		return true
 	}
+	return w.keepTogether != nil && w.keepTogether(w.left, t.value)
+}
+
+// keepsTogether returns whether any text is kept together across a space;
+// see KeepTogether and KeepAbbreviations.
+func (w *Wrapper) keepsTogether() bool {
+	return w.keepTogether != nil || w.KeepAbbreviations
+}
+
+// isAbbreviation returns whether s, the text before a space, is one of the
+// abbreviations; an opening bracket or quote before it is ignored.
+func isAbbreviation(s string) bool {
+	s = strings.ToLower(strings.TrimLeft(s, "([{\"'"))
+	for _, a := range abbreviations {
+		if s == a {
+			return true
+		}
+	}
 	return false
 }
-func (w *Wrapper) shellComment() {
-	w.b = append(w.b, shellComment...)
-	w.l = 2
+
+// breakAtClause breaks the current line at its clause break point, if it has
+// one. Returns whether the line was broken.
+func (w *Wrapper) breakAtClause() bool {
+	return w.breakAt(w.clause)
+}
+
+// breakAt breaks the current line at the break point, if it is set; the text
+// after the break point is moved to the new line. Returns whether the line
+// was broken.
+func (w *Wrapper) breakAt(bp breakPoint) bool {
+	if bp.pos == 0 || bp.next == len(w.b) {
+		return false
+	}
+	tail := append([]byte(nil), w.b[bp.next:]...)
+	var spaces []breakPoint // the tail's space break points; see MinWordsPerLine
+	for _, s := range w.spaces {
+		if s.pos >= bp.next {
+			spaces = append(spaces, s)
+		}
+	}
+	l := w.l - bp.l
+	w.b = w.b[:bp.pos] // the whitespace at the break point is elided
+	// the priorToken is part of the tail; it must not be elided by nl.
+	prior := w.priorToken
+	w.priorToken = token{}
+	// the new line starts with the SGR state at the break point; the tail
+	// has any changes to it.
+	sgr := w.sgr
+	w.sgr = []byte(bp.sgr)
+	// the tail isn't on the line yet, so the line isn't balanced; see
+	// MinWordsPerLine.
+	w.newLine(w.softBreak)
+	w.softSinceNL = true
+	w.sgr = sgr
+	w.priorToken = prior
+	w.continueIndent()
+	for _, s := range spaces {
+		s.pos += len(w.b) - bp.next
+		s.next += len(w.b) - bp.next
+		w.spaces = append(w.spaces, s)
+	}
+	w.b = append(w.b, tail...)
+	w.l += l
+	return true
+}
+
+// breakPoint returns a break point at the whitespace token t, which was just
+// written to b.
+func (w *Wrapper) breakPoint(t token) breakPoint {
+	return breakPoint{pos: len(w.b) - len(t.value), next: len(w.b), l: w.l, sgr: string(w.sgr)}
+}
+
+// isSentenceEnd returns whether the token is text that ends a sentence. If
+// KeepAbbreviations, an abbreviation doesn't end one.
+func (w *Wrapper) isSentenceEnd(t token) bool {
+	if w.KeepAbbreviations && isAbbreviation(t.value) {
+		return false
+	}
+	return endsWithAny(t, ".?!")
+}
+
+// isClauseEnd returns whether the token is text that ends a clause.
+func isClauseEnd(t token) bool {
+	return endsWithAny(t, ",;:")
+}
+
+// endsWithAny returns whether the token is text whose last char is one of
+// chars.
+func endsWithAny(t token, chars string) bool {
+	if t.typ != tokenText || t.value == "" {
+		return false
+	}
+	return strings.IndexByte(chars, t.value[len(t.value)-1]) >= 0
+}
+
+func (w *Wrapper) commentBegin() {
+	switch w.CommentStyle {
+	case CPPComment, ShellComment, SQLComment, LispComment:
+		w.lineComment()
+	case CComment:
+		w.b = append(w.b, cCommentBegin...)
+		w.blockLine()
+	}
+	w.bol = len(w.b)
+}
+
+func (w *Wrapper) commentEnd() {
+	if w.CommentStyle != CComment {
+		if w.TrailingCommentLine {
+			w.trailingCommentLine()
+		}
+		return
+	}
+	// the comment end is on its own line and isn't indented.
+	if !w.isBlankBlockLine() {
+		w.nl()
+	}
+	w.b = w.b[:w.lineStart()]
+	w.b = append(w.b, cCommentEnd...)
+	w.delimited = true
+}
+
+// trailingCommentLine ends line comments with a line that is only the
+// comment marker; see TrailingCommentLine.
+func (w *Wrapper) trailingCommentLine() {
+	m := w.lineCommentMarker()
+	if m == nil {
+		return
+	}
+	line := w.b[w.lineStart():]
+	if !bytes.Equal(line, m) && !bytes.Equal(line, w.lineCommentPrefix()) {
+		// the line's trailing whitespace was trimmed when the input ended.
+		w.priorToken = token{}
+		w.nl()
+	}
+	w.b = append(w.b[:w.lineStart()], m...)
+}
+
+// blockLine starts a line within a CComment block.
+func (w *Wrapper) blockLine() {
+	w.indent()
+	if len(w.blockPrefix) > 0 {
+		w.b = append(w.b, w.blockPrefix...)
+		w.l += w.commentPrefixLen()
+	}
+}
+
+// padLines pads each line of the output that is narrower than the line
+// length with the fill char; see PadToWidth. The padding goes before the soft
+// break marker, if the line ends with one. The CComment delimiter lines
+// aren't padded; they would only get trailing whitespace.
+func (w *Wrapper) padLines() {
+	w.b = w.padded(w.b)
+}
+
+// padded returns the lines in src, each padded as by padLines.
+func (w *Wrapper) padded(src []byte) []byte {
+	var b []byte
+	for len(src) > 0 {
+		i := bytes.IndexByte(src, nl) + 1
+		if i == 0 {
+			i = len(src)
+		}
+		line := src[:i]
+		src = src[i:]
+		end := bytes.TrimSuffix(line, []byte{nl})
+		if len(w.softBreak) > 0 && bytes.HasSuffix(end, w.softBreak) {
+			end = end[:len(end)-len(w.softBreak)]
+		}
+		b = append(b, end...)
+		if w.CommentStyle == CComment && isCCommentDelimiter(end) {
+			b = append(b, line[len(end):]...)
+			continue
+		}
+		if n := w.lineLength() - w.lexOptions().measure(string(end)); n > 0 {
+			b = append(b, w.fillText(n)...)
+		}
+		b = append(b, line[len(end):]...)
+	}
+	return b
+}
+
+// flush counts the lines before the prior line and drops them from b; they
+// are done, as only the current and prior lines change, e.g. see balance.
+// The lines are padded before they are counted; see PadToWidth. Lines aren't
+// dropped when there's a MaxLines; the cut may need them.
+func (w *Wrapper) flush() {
+	k := w.prevStart
+	w.prevStart = 0
+	if w.MaxLines > 0 {
+		return
+	}
+	done := w.b[:k]
+	w.countedNL += bytes.Count(done, []byte{nl})
+	if w.PadToWidth {
+		done = w.padded(done)
+	}
+	w.counted += len(done)
+	w.b = append(w.b[:0], w.b[k:]...)
+	w.bol -= k
+	w.prevBOL -= k
+	w.prevEOL -= k
+	w.clause.shift(-k)
+	w.space.shift(-k)
+	w.prevSpace.shift(-k)
+	w.word.shift(-k)
+	for i := range w.spaces {
+		w.spaces[i].shift(-k)
+	}
+	for i := range w.prevSpaces {
+		w.prevSpaces[i].shift(-k)
+	}
+}
+
+// isCCommentDelimiter returns whether line is the CComment begin or end.
+func isCCommentDelimiter(line []byte) bool {
+	return bytes.Equal(line, bytes.TrimSuffix(cCommentBegin, []byte{nl})) || bytes.Equal(line, bytes.TrimSuffix(cCommentEnd, []byte{nl}))
+}
+
+// pad pads the line that starts at start, which is the current line, with
+// the fill char to n chars.
+func (w *Wrapper) pad(start, n int) {
+	if n -= w.lexOptions().measure(string(w.b[start:])); n > 0 {
+		w.b = append(w.b, w.fillText(n)...)
+		w.l += n
+	}
+}
+
+// fillText returns n chars of padding: as many fill chars as fit, followed by
+// spaces for the rest, if the fill char is wider than a char.
+func (w *Wrapper) fillText(n int) string {
+	fill := w.fill
+	if fill == 0 {
+		fill = ' '
+	}
+	width := w.lexOptions().measure(string(fill))
+	if width <= 0 {
+		return strings.Repeat(" ", n)
+	}
+	return strings.Repeat(string(fill), n/width) + strings.Repeat(" ", n%width)
+}
+
+// lineStart returns the index in b of the start of the current line.
+func (w *Wrapper) lineStart() int {
+	return bytes.LastIndexByte(w.b, nl) + 1
+}
+
+// isBlankBlockLine returns whether the current line within a CComment block
+// is blank, i.e. it only has the indent and the block line prefix.
+func (w *Wrapper) isBlankBlockLine() bool {
+	line := w.b[w.lineStart():]
+	return len(line) == len(w.indentText)+len(w.blockPrefix) &&
+		bytes.HasPrefix(line, w.indentText) && bytes.HasSuffix(line, w.blockPrefix)
 }
 
-func (w *Wrapper) cppComment() {
-	w.b = append(w.b, cppComment...)
-	w.l = 3
+func (w *Wrapper) lineComment() bool {
+	m := w.lineCommentMarker()
+	if m == nil {
+		return false
+	}
+	w.b = append(w.b, m...)
+	w.b = append(w.b, w.commentSep...)
+	w.l = w.commentPrefixLen()
+	return true
 }
 
 func (w *Wrapper) nl() {
-	// see if the priorToken was a tokenSpace; if so back up to elide
+	w.newLine(nil)
+	w.softSinceNL = false
+}
+
+// isMarkdownBreak returns whether the new line, t, is a Markdown break, i.e.
+// it is kept: either it ends a line with a hard line break, two spaces or a
+// backslash, or it is part of a paragraph break.
+func (w *Wrapper) isMarkdownBreak(t token) bool {
+	switch {
+	case w.priorToken.typ == tokenNL, w.priorToken.typ == tokenNone: // a blank line
+		return true
+	case strings.TrimSpace(w.lexer.line(t.pos+1)) == "": // the next line is blank
+		return true
+	case isTwoSpaceBreak(w.priorToken):
+		return true
+	}
+	return w.priorToken.typ == tokenText && strings.HasSuffix(w.priorToken.value, "\\")
+}
+
+// isTwoSpaceBreak returns whether t is the two, or more, spaces that end a
+// line with a Markdown hard line break.
+func isTwoSpaceBreak(t token) bool {
+	return t.typ == tokenWhitespace && strings.HasSuffix(t.value, "  ")
+}
+
+// softNL starts a new line for a break inserted by the wrapper, as opposed to
+// one that was in the input. The line ends with the softBreak marker, if
+// there is one.
+func (w *Wrapper) softNL() {
+	if w.MinWordsPerLine > 1 {
+		w.balance()
+	}
+	w.newLine(w.softBreak)
+	w.softSinceNL = true
+	w.continueIndent()
+}
+
+// atLineStart returns whether the current line is empty, other than the
+// leading tabs that are kept for KeepLeadingTabs, which a new line would start
+// with too.
+func (w *Wrapper) atLineStart() bool {
+	if w.leadTabs == "" {
+		return len(w.b) == w.bol
+	}
+	return len(bytes.TrimRight(w.b[w.bol:], " \t")) == 0
+}
+
+// continueIndent starts a line that follows an inserted break with the
+// input line's leading tabs; see KeepLeadingTabs.
+func (w *Wrapper) continueIndent() {
+	if w.leadTabs == "" {
+		return
+	}
+	w.b = append(w.b, w.leadTabs...)
+	w.l += w.lexOptions().measure(w.leadTabs)
+}
+
+// newLine ends the current line with marker, which may be nil, and starts a
+// new one.
+func (w *Wrapper) newLine(marker []byte) {
+	// see if the priorToken was a tokenWhitespace; if so back up to elide
 	// trailing spaces from the line prior to a nl
-	if w.priorToken.typ == tokenSpace {
+	if w.priorToken.typ == tokenWhitespace {
 		w.b = w.b[:len(w.b)-len(w.priorToken.value)]
+		w.elide(w.priorToken.value)
 	}
 
 	// If a line comment see if the current line is a blank comment line and elide
 	// the trailing space if it is.
 	w.cleanBlankCommentLine()
+	w.cleanBlankIndentLine()
+	w.trimTrailing()
+	w.kept = false
+	if w.count {
+		w.prevStart = w.lineStart()
+	}
+	w.prevBOL, w.prevEOL = w.bol, len(w.b)
+	w.prevSpaces, w.spaces = lineSpaces(w.spaces, w.prevBOL, w.prevEOL), w.prevSpaces[:0]
+
+	w.lines++
+	if w.lines == w.MaxLines+1 {
+		w.cut = len(w.b)
+		w.cutBOL = w.bol
+	}
 
 	// newline
+	if len(w.sgr) > 0 {
+		w.b = append(w.b, sgrReset...)
+	}
+	w.b = append(w.b, marker...)
 	w.b = append(w.b, nl)
 	w.l = 0
+	w.sentenceEnd = false
+	w.spaceBreak = false
+	w.clause = breakPoint{}
+	w.space = breakPoint{}
+	w.prevSpace = breakPoint{}
+	w.word = breakPoint{}
+	w.held = false
 	b := w.lineComment() // add a new line if applicable
-	if b {               // if this is a line comment no indent is done
+	switch {
+	case b && len(w.label) == 0: // if this is a line comment no indent is done
+	case w.CommentStyle == CComment:
+		w.blockLine()
+	default:
+		// a label's hanging indent follows a line comment's prefix.
+		w.indent()
+	}
+	w.b = append(w.b, w.sgr...)
+	w.bol = len(w.b)
+}
+
+// balance moves words from the end of the prior line to the start of the
+// current line, which is about to be ended by a soft break, until it has
+// MinWordsPerLine words, as long as they fit and the prior line keeps at
+// least MinWordsPerLine words. Only a prior line that was ended by a soft
+// break, i.e. one in the same paragraph, gives up words.
+func (w *Wrapper) balance() {
+	// the prior line may be cut, see MaxLines, or end with an SGR reset; it is
+	// left as is.
+	if !w.softSinceNL || w.prevEOL > w.bol || w.MaxLines > 0 || len(w.sgr) > 0 {
+		return
+	}
+	eol := len(w.b)
+	if w.priorToken.typ == tokenWhitespace { // it will be elided
+		eol -= len(w.priorToken.value)
+	}
+	w.spaces = lineSpaces(w.spaces, w.bol, eol)
+	for lineWords(w.spaces, w.bol, eol) < w.MinWordsPerLine {
+		if lineWords(w.prevSpaces, w.prevBOL, w.prevEOL) <= w.MinWordsPerLine {
+			return
+		}
+		// the word after the prior line's last space is moved; the space
+		// is elided.
+		bp := w.prevSpaces[len(w.prevSpaces)-1]
+		w.prevSpaces = w.prevSpaces[:len(w.prevSpaces)-1]
+		end := bp.pos
+		for len(w.prevSpaces) > 0 && w.prevSpaces[len(w.prevSpaces)-1].next == end {
+			end = w.prevSpaces[len(w.prevSpaces)-1].pos
+			w.prevSpaces = w.prevSpaces[:len(w.prevSpaces)-1]
+		}
+		word := string(w.b[bp.next:w.prevEOL])
+		n := w.lexOptions().measure(word) + 1
+		if w.priorToken.typ == tokenWhitespace {
+			n -= w.priorToken.len
+		}
+		if !w.fits(n) {
+			return
+		}
+		// the prior line's end, e.g. the new line and the prefix of the
+		// current line, is kept.
+		sep := append([]byte(nil), w.b[w.prevEOL:w.bol]...)
+		line := append([]byte(word+" "), w.b[w.bol:]...)
+		w.b = append(append(w.b[:end], sep...), line...)
+		bol := end + len(sep)
+		d := bol + len(word) + 1 - w.bol
+		for i := range w.spaces {
+			w.spaces[i].pos += d
+			w.spaces[i].next += d
+		}
+		w.spaces = append([]breakPoint{{pos: bol + len(word), next: bol + len(word) + 1}}, w.spaces...)
+		w.prevEOL = end
+		w.bol = bol
+		eol += d
+		w.l += w.lexOptions().measure(word) + 1
+	}
+}
+
+// lineSpaces returns the space break points in spaces that are between words
+// of the line b[bol:eol], i.e. not its leading or trailing whitespace. The
+// break points are filtered in place.
+func lineSpaces(spaces []breakPoint, bol, eol int) []breakPoint {
+	n := spaces[:0]
+	for _, s := range spaces {
+		if s.pos > bol && s.next < eol {
+			n = append(n, s)
+		}
+	}
+	return n
+}
+
+// lineWords returns the number of words on the line b[bol:eol], whose space
+// break points, between its words, are spaces.
+func lineWords(spaces []breakPoint, bol, eol int) int {
+	if eol <= bol {
+		return 0
+	}
+	return len(spaces) + 1
+}
+
+// updateSGR updates the active SGR state with the SGR escape sequences in s.
+func (w *Wrapper) updateSGR(s string) {
+	for {
+		i := strings.IndexByte(s, esc)
+		if i < 0 {
+			return
+		}
+		s = s[i:]
+		n := ansiLen(s)
+		if n == 0 {
+			s = s[1:]
+			continue
+		}
+		seq := s[:n]
+		s = s[n:]
+		if seq[n-1] != 'm' { // not an SGR sequence
+			continue
+		}
+		switch params := seq[2 : n-1]; {
+		case params == "" || params == "0":
+			w.sgr = w.sgr[:0]
+		case strings.HasPrefix(params, "0;"):
+			w.sgr = append(w.sgr[:0], seq...)
+		default:
+			w.sgr = append(w.sgr, seq...)
+		}
+	}
+}
+
+// trimTrailing removes any trailing whitespace from the current line, if
+// TrimTrailing.
+func (w *Wrapper) trimTrailing() {
+	if !w.TrimTrailing || w.kept {
 		return
 	}
+	start := w.lineStart()
+	line := bytes.TrimRightFunc(w.b[start:], isTrailingSpace)
+	w.elide(string(w.b[start+len(line):]))
+	w.b = w.b[:start+len(line)]
+	if w.bol > len(w.b) {
+		w.bol = len(w.b)
+	}
+}
+
+// elide counts the chars of s, whitespace that was elided from the output.
+func (w *Wrapper) elide(s string) {
+	w.elided += utf8.RuneCountInString(s)
+}
+
+// isTrailingSpace returns whether r is whitespace that is trimmed from the end
+// of a line: either unicode whitespace or whitespace that a line can be
+// broken at, e.g. the zero width space.
+func isTrailingSpace(r rune) bool {
+	if unicode.IsSpace(r) {
+		return true
+	}
+	t, ok := key[string(r)]
+	return ok && isSpace(t)
+}
+
+// indent indents the current line, if there is any indentText.
+func (w *Wrapper) indent() {
 	if w.indentLen > 0 {
 		w.b = append(w.b, w.indentText...)
 		w.l += w.indentLen
 	}
-
 }
 
 // if the text is being wrapped as line comments and current line is a
 // blank comment line, e.g. // with no text, make sure the trailing space
 // is elided: "// " becomes "//" and "# " becomes "#"
 func (w *Wrapper) cleanBlankCommentLine() {
-	switch w.CommentStyle {
-	case CPPComment:
-		w.cleanBlankCPPCommentLine()
-	case ShellComment:
-		w.cleanBlankShellCommentLine()
+	if w.CommentStyle == CComment {
+		w.cleanBlankBlockLine()
+		return
+	}
+	p := w.lineCommentPrefix()
+	if p == nil {
+		return
+	}
+	if line := w.b[w.lineStart():]; bytes.Equal(line, p) || bytes.Equal(line, append(p[:len(p):len(p)], w.indentText...)) {
+		w.b = bytes.TrimRightFunc(w.b, unicode.IsSpace)
 	}
 }
 
-func (w *Wrapper) cleanBlankCPPCommentLine() {
-	if w.b[len(w.b)-1] == 0x20 {
-		if w.b[len(w.b)-2] == '/' && w.b[len(w.b)-3] == '/' {
-			w.b = w.b[:len(w.b)-1]
+// cleanBlankIndentLine elides the trailing whitespace of the indent from a
+// blank line, i.e. one that only has the indent.
+func (w *Wrapper) cleanBlankIndentLine() {
+	if len(w.indentText) == 0 || w.CommentStyle == CComment {
+		return
+	}
+	start := w.lineStart()
+	if bytes.Equal(w.b[start:], w.indentText) {
+		w.b = bytes.TrimRightFunc(w.b[:start+len(w.indentText)], unicode.IsSpace)
+		if len(w.b) < start {
+			w.b = w.b[:start]
+		}
+		if w.bol > len(w.b) {
+			w.bol = len(w.b)
 		}
 	}
 }
 
-func (w *Wrapper) cleanBlankShellCommentLine() {
-	if w.b[len(w.b)-1] == 0x20 {
-		if w.b[len(w.b)-2] == '#' {
-			w.b = w.b[:len(w.b)-1]
-		}
+// cleanBlankBlockLine elides the indent and block line prefix from a blank
+// line within a CComment block. If PrefixBlankBlockLines, the prefix, without
+// any trailing whitespace, is kept.
+func (w *Wrapper) cleanBlankBlockLine() {
+	if !w.isBlankBlockLine() {
+		return
+	}
+	if !w.PrefixBlankBlockLines {
+		w.b = w.b[:w.lineStart()]
+		return
 	}
+	start := w.lineStart()
+	w.b = append(w.b[:start], bytes.TrimRight(w.b[start:], " \t")...)
 }
@@ -14,10 +14,33 @@
 package linewrap
 
 import (
+	"errors"
+	"io"
+	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
+// leaks returns the number of go routines that are still running after fn
+// has been called n times, e.g. lexers that weren't drained.
+func leaks(n int, fn func()) int {
+	before := runtime.NumGoroutine()
+	for i := 0; i < n; i++ {
+		fn()
+	}
+	// a drained lexer's go routine may not have exited yet.
+	var after int
+	for i := 0; i < 100; i++ {
+		if after = runtime.NumGoroutine(); after <= before {
+			return 0
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return after - before
+}
+
 func TestWrapLine(t *testing.T) {
 	tests := []struct {
 		s          string
@@ -46,13 +69,13 @@ func TestWrapLine(t *testing.T) {
 		// 15
 		{"Reality is frequently inaccurate.     One is never alone with a rubber duck.", 40, 4, "", "Reality is frequently inaccurate.\nOne is never alone with a rubber duck."},
 		{"A common mistake\n that people make when trying to design something completely foolproof is to underestimate the ingenuity of complete fools.", 20, 4, "", "A common mistake\nthat people make\nwhen trying to\ndesign something\ncompletely\nfoolproof is to\nunderestimate the\ningenuity of\ncomplete fools."},
-		{"못\t알아\t듣겠어요\t전혀\t모르겠어요", 20, 4, "", "못\t알아\t듣겠어요\t\n전혀\t모르겠어요"},
-		{"못\t알아\t듣겠어요\t전혀\t모르겠어요", 20, 4, "    ", "못\t알아\t듣겠어요\t\n    전혀\t모르겠어요"},
-		{"못\t알아\t듣겠어요\t전혀\t모르겠어요", 20, 4, "\t", "못\t알아\t듣겠어요\t\n\t전혀\t모르겠어요"},
+		{"못\t알아\t듣겠어요\t전혀\t모르겠어요", 20, 4, "", "못\t알아\t듣겠어요\n전혀\t모르겠어요"},
+		{"못\t알아\t듣겠어요\t전혀\t모르겠어요", 20, 4, "    ", "못\t알아\t듣겠어요\n    전혀\t모르겠어요"},
+		{"못\t알아\t듣겠어요\t전혀\t모르겠어요", 20, 4, "\t", "못\t알아\t듣겠어요\n\t전혀\t모르겠어요"},
 		// 20
-		{"hello\nΧαίρετε\t\tЗдравствуйте", 20, 4, "", "hello\nΧαίρετε\t\t\nЗдравствуйте"},
-		{"hello\nΧαίρετε\t\tЗдравствуйте", 20, 4, "    ", "hello\n    Χαίρετε\t\t\n    Здравствуйте"},
-		{"hello\nΧαίρετε\t\tЗдравствуйте", 20, 4, "\t", "hello\n\tΧαίρετε\t\t\n\tЗдравствуйте"},
+		{"hello\nΧαίρετε\t\tЗдравствуйте", 20, 4, "", "hello\nΧαίρετε\nЗдравствуйте"},
+		{"hello\nΧαίρετε\t\tЗдравствуйте", 20, 4, "    ", "hello\n    Χαίρετε\n    Здравствуйте"},
+		{"hello\nΧαίρετε\t\tЗдравствуйте", 20, 4, "\t", "hello\n\tΧαίρετε\n\tЗдравствуйте"},
 		{"Reality is\u00A0frequently inaccurate.", 20, 4, "", "Reality\nis\u00A0frequently\ninaccurate."},
 		{"Reality is\u00a0frequently inaccurate.", 20, 4, "", "Reality\nis\u00a0frequently\ninaccurate."},
 		// 25
@@ -72,6 +95,12 @@ func TestWrapLine(t *testing.T) {
 		{"Space is big. You just won't believe how vastly, hugely, mind\u00adbogglingly big it is.", 34, 4, "", "Space is big. You just won't\nbelieve how vastly, hugely, mind\u00ad\nbogglingly big it is."},
 		{"Space is big. You just won't believe how vastly, hugely, mind\u2011bogglingly big it is.", 34, 4, "", "Space is big. You just won't\nbelieve how vastly, hugely,\nmind\u2011bogglingly big it is."},
 		{"Space is big. You just won't believe how vastly, hugely, mind\u207bbogglingly big it is.", 35, 4, "", "Space is big. You just won't\nbelieve how vastly, hugely, mind\u207b\nbogglingly big it is."},
+		{"Reality is\u2060frequently inaccurate.", 20, 4, "", "Reality\nis\u2060frequently\ninaccurate."},
+		// 40
+		{"Reality is\u2060 frequently inaccurate.", 20, 4, "", "Reality\nis\u2060 frequently\ninaccurate."},
+		{"Reality is\uFEFF frequently inaccurate.", 20, 4, "", "Reality\nis\uFEFF frequently\ninaccurate."},
+		// trailing whitespace that doesn't fit before a new line doesn't break the line twice.
+		{"aaa   bbb   ccc   ddd  \n   eee", 10, 4, "", "aaa   bbb\nccc   ddd\neee"},
 	}
 
 	w := New()
@@ -241,6 +270,8 @@ func TestCommentStyleStringer(t *testing.T) {
 		{"c++", CPPComment, "c++ style comments"},
 		{"shell", ShellComment, "shell style comments"},
 		{"c", CComment, "c style comments"},
+		{"sql", SQLComment, "sql style comments"},
+		{"lisp", LispComment, "lisp style comments"},
 	}
 
 	for _, test := range tests {
@@ -266,6 +297,9 @@ func TestParseAsCommentStyle(t *testing.T) {
 		{"shell", ShellComment},
 		{"perl", ShellComment},
 		{"PERL", ShellComment},
+		{"sql", SQLComment},
+		{"SQL", SQLComment},
+		{"lisp", LispComment},
 	}
 
 	for _, test := range tests {
@@ -275,3 +309,3384 @@ func TestParseAsCommentStyle(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCommentStyleStrict(t *testing.T) {
+	tests := []struct {
+		value string
+		style CommentStyle
+		err   string
+	}{
+		{"", NoComment, `unknown comment style: ""`},
+		{"none", NoComment, ""},
+		{"None", NoComment, ""},
+		{"c", CComment, ""},
+		{"cpp", CPPComment, ""},
+		{"C++", CPPComment, ""},
+		{"shell", ShellComment, ""},
+		{"perl", ShellComment, ""},
+		{"sql", SQLComment, ""},
+		{"lisp", LispComment, ""},
+		{"c+", NoComment, `unknown comment style: "c+"`},
+		{"pyhton", NoComment, `unknown comment style: "pyhton"`},
+	}
+
+	for _, test := range tests {
+		c, err := ParseCommentStyleStrict(test.value)
+		if err != nil {
+			if err.Error() != test.err {
+				t.Errorf("%s: got error %q want %q", test.value, err, test.err)
+			}
+		} else if test.err != "" {
+			t.Errorf("%s: got no error want %q", test.value, test.err)
+		}
+		if c != test.style {
+			t.Errorf("%s: got %q want %q", test.value, c, test.style)
+		}
+	}
+}
+
+func TestLengthIncludesPrefix(t *testing.T) {
+	tests := []struct {
+		includes bool
+		expected string
+	}{
+		{true, "// One is never\n// alone with a\n// rubber duck."},
+		{false, "// One is never alone\n// with a rubber duck."},
+	}
+	w := New()
+	w.CommentStyle = CPPComment
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.LengthIncludesPrefix = test.includes
+		s, err := w.String("One is never alone with a rubber duck.")
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestSentencePerLine(t *testing.T) {
+	tests := []struct {
+		s        string
+		length   int
+		expected string
+	}{
+		{"Space is big. Really big! You just won't believe how vastly, hugely, mind-bogglingly big it is? I mean, you may think it's a long way down the road to the chemist's.", 80,
+			"Space is big.\nReally big!\nYou just won't believe how vastly, hugely, mind-bogglingly big it is?\nI mean, you may think it's a long way down the road to the chemist's."},
+		{"Space is big. Really big! You just won't believe how vastly, hugely, mind-bogglingly big it is?", 30,
+			"Space is big.\nReally big!\nYou just won't believe how\nvastly, hugely, mind-\nbogglingly big it is?"},
+		{"Space is big. \nReally big!", 30, "Space is big.\nReally big!"},
+		{"The answer is 4.2 not 42.", 30, "The answer is 4.2 not 42."},
+	}
+	w := New()
+	w.SentencePerLine = true
+	for i, test := range tests {
+		w.Reset()
+		w.Length = test.length
+		s, err := w.String(test.s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestClauseBreaks(t *testing.T) {
+	s := "Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is. I mean, you may think it's a long way down the road to the chemist's, but that's just peanuts to space."
+	tests := []struct {
+		clauseBreaks bool
+		expected     string
+	}{
+		{false, "Space is big. You just won't believe\nhow vastly, hugely, mind-bogglingly big\nit is. I mean, you may think it's a\nlong way down the road to the\nchemist's, but that's just peanuts to\nspace."},
+		{true, "Space is big. You just won't believe\nhow vastly, hugely,\nmind-bogglingly big it is. I mean,\nyou may think it's a long way down the\nroad to the chemist's,\nbut that's just peanuts to space."},
+	}
+	w := New()
+	w.Length = 40
+	for i, test := range tests {
+		w.Reset()
+		w.ClauseBreaks = test.clauseBreaks
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestWrapToWidth(t *testing.T) {
+	s := "Reality is frequently inaccurate. One is never alone with a rubber duck."
+	tests := []struct {
+		width    int
+		expected string
+	}{
+		{34, "Reality is frequently inaccurate.\nOne is never alone with a rubber\nduck."},
+		{20, "Reality is\nfrequently\ninaccurate. One is\nnever alone with a\nrubber duck."},
+		{34, "Reality is frequently inaccurate.\nOne is never alone with a rubber\nduck."},
+	}
+	w := New()
+	for i, test := range tests {
+		c, err := w.WrapToWidth(s, test.width)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+		if w.Length != LineLength {
+			t.Errorf("%d: Length: got %d want %d", i, w.Length, LineLength)
+		}
+	}
+}
+
+func TestEmailQuotes(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"> A short quote.", "> A short quote."},
+		{
+			"Sounds good, see you then.\n\nOn Monday, Ann wrote:\n> I think we should meet on Tuesday\n> to go over the plan, if\n> that works for you.\n>\n> > Can we meet this week to go over the plan for the release?\n> > Thanks.\n",
+			"Sounds good, see you then.\n\nOn Monday, Ann wrote:\n> I think we should meet on\n> Tuesday to go over the\n> plan, if that works for\n> you.\n>\n> > Can we meet this week to\n> > go over the plan for the\n> > release? Thanks.\n",
+		},
+		// a reply between quotes
+		{
+			">> Can we meet this week?\n> Yes, on Tuesday.\nTuesday works for me too.",
+			">> Can we meet this week?\n> Yes, on Tuesday.\nTuesday works for me too.",
+		},
+		// the marker is kept, other than extra trailing spaces
+		{"> > quoted text", "> > quoted text"},
+		{">>  Can we meet this week to go over the plan?", ">> Can we meet this week to\n>> go over the plan?"},
+	}
+	w := New()
+	w.Length = 30
+	w.EmailQuotes = true
+	for i, test := range tests {
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+	// the quote marker follows the comment prefix on every line.
+	w.CommentStyle = CPPComment
+	s, err := w.String("Sounds good.\n> I think we should meet on Tuesday to go over the plan.\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	expected := "// Sounds good.\n// > I think we should meet\n// > on Tuesday to go over\n// > the plan.\n"
+	if s != expected {
+		t.Errorf("got %q want %q", s, expected)
+	}
+}
+
+func TestNewline(t *testing.T) {
+	tests := []struct {
+		newline  string
+		value    string
+		expected string
+	}{
+		{"", "Loading   the files...   \nDone.  ", "Loading\nthe\nfiles...\nDone."},
+		{"\r", "Loading   the files...   \nDone.  ", "Loading\rthe\rfiles...\rDone."},
+		{"\r", "Downloading the data   ", "Downloading\rthe data"},
+		{"\r\n", "Loading   the files...   \r\nDone.  ", "Loading\r\nthe\r\nfiles...\r\nDone."},
+	}
+	w := New()
+	w.Length = 12
+	for i, test := range tests {
+		w.Reset()
+		w.Newline(test.newline)
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		w.Reset()
+		rs, err := w.Runes([]rune(test.value))
+		if err != nil {
+			t.Errorf("%d: runes: unexpected error: %q", i, err)
+			continue
+		}
+		if string(rs) != test.expected {
+			t.Errorf("%d: runes: got %q want %q", i, string(rs), test.expected)
+		}
+	}
+	// the output of the helpers that build on String has the line ending too.
+	w.Newline("\r")
+	s, overflow, err := w.FitBox("one two three four", 8, 2)
+	if err != nil {
+		t.Errorf("FitBox: unexpected error: %q", err)
+	}
+	if s != "one two\rthree…" || !overflow {
+		t.Errorf("FitBox: got %q %t want %q true", s, overflow, "one two\rthree…")
+	}
+}
+
+func TestFitBox(t *testing.T) {
+	tests := []struct {
+		value    string
+		rows     int
+		expected string
+		overflow bool
+	}{
+		{"Hello, world", 3, "Hello,\nworld", false},
+		{"Fits exactly in the box", 3, "Fits\nexactly in\nthe box", false},
+		{"The quick brown fox jumps over the lazy dog.", 0, "The quick\nbrown fox\njumps over\nthe lazy\ndog.", false},
+		// too many rows
+		{"The quick brown fox jumps over the lazy dog.", 3, "The quick\nbrown fox\njumps…", true},
+		// too many columns
+		{"A supercalifragilistic word", 3, "A\nsupercalifragilistic\nword", true},
+		// too many of both
+		{"A supercalifragilistic word", 2, "A\nsupercali…", true},
+		// blank lines past the last row aren't an overflow
+		{"one\n\n\n\n", 2, "one\n", false},
+	}
+	w := New()
+	for i, test := range tests {
+		s, overflow, err := w.FitBox(test.value, 10, test.rows)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		if overflow != test.overflow {
+			t.Errorf("%d: overflow: got %t want %t", i, overflow, test.overflow)
+		}
+	}
+	if w.Length != LineLength || w.MaxLines != 0 || w.FillExact {
+		t.Errorf("the Wrapper's configuration was changed: Length %d MaxLines %d FillExact %t", w.Length, w.MaxLines, w.FillExact)
+	}
+	// each call wraps with a copy of w, whose lexer must finish.
+	n := leaks(100, func() {
+		w.FitBox("The quick brown fox jumps over the lazy dog.", 10, 2)
+	})
+	if n > 0 {
+		t.Errorf("%d go routines were leaked", n)
+	}
+}
+
+func TestReflowOn(t *testing.T) {
+	widths := make(chan int)
+	w := New()
+	out := w.ReflowOn("The quick brown fox jumps over the lazy dog.", widths)
+	w.Length = 5 // the configuration as of the call is used
+	tests := []struct {
+		width    int
+		expected string
+	}{
+		{20, "The quick brown fox\njumps over the lazy\ndog."},
+		{12, "The quick\nbrown fox\njumps over\nthe lazy\ndog."},
+	}
+	for i, test := range tests {
+		widths <- test.width
+		s := <-out
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+	close(widths)
+	if s, ok := <-out; ok {
+		t.Errorf("expected the output to be closed; got %q", s)
+	}
+}
+
+func TestStringComment(t *testing.T) {
+	s := "Reality is frequently inaccurate. One is never alone with a rubber duck."
+	tests := []struct {
+		style    CommentStyle
+		expected string
+	}{
+		{CPPComment, "// Reality is frequently\n// inaccurate. One is never\n// alone with a rubber\n// duck."},
+		{ShellComment, "# Reality is frequently\n# inaccurate. One is never\n# alone with a rubber duck."},
+		{NoComment, "Reality is frequently\ninaccurate. One is never\nalone with a rubber duck."},
+	}
+	w := New()
+	w.Length = 28
+	for i, test := range tests {
+		c, err := w.StringComment(s, test.style)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+		if w.CommentStyle != NoComment {
+			t.Errorf("%d: CommentStyle: got %s want %s", i, w.CommentStyle, NoComment)
+		}
+	}
+}
+
+func TestCCommentIndent(t *testing.T) {
+	tests := []struct {
+		s          string
+		indentText string
+		expected   string
+	}{
+		{"Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.", "", "/*\nSpace is big. You just won't believe\nhow vastly, hugely, mind-bogglingly big\nit is.\n*/\n"},
+		{"Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.", "  ", "/*\n  Space is big. You just won't believe\n  how vastly, hugely, mind-bogglingly\n  big it is.\n*/\n"},
+		{"Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.\n", "  ", "/*\n  Space is big. You just won't believe\n  how vastly, hugely, mind-bogglingly\n  big it is.\n*/\n"},
+		{"Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.", "\t", "/*\n\tSpace is big. You just won't\n\tbelieve how vastly, hugely,\n\tmind-bogglingly big it is.\n*/\n"},
+	}
+	w := New()
+	w.CommentStyle = CComment
+	w.Length = 40
+	for i, test := range tests {
+		w.Reset()
+		w.IndentText(test.indentText)
+		s, err := w.String(test.s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestRunes(t *testing.T) {
+	tests := []struct {
+		s          string
+		indentText string
+		style      CommentStyle
+	}{
+		{"", "", NoComment},
+		{"Reality is frequently inaccurate. One is never alone with a rubber duck.", "", NoComment},
+		{"못\t알아\t듣겠어요\t전혀\t모르겠어요", "    ", NoComment},
+		{"hello\nΧαίρετε\t\tЗдравствуйте", "\t", NoComment},
+		{"Reality is\u2001frequently inaccurate. Space is big.\r\nmind\u00adbogglingly", "", CPPComment},
+		{"Reality is\uFEFFfrequently inaccurate.", "", CComment},
+	}
+	w := New()
+	w.Length = 20
+	w.TabSize(4)
+	for i, test := range tests {
+		w.CommentStyle = test.style
+		w.IndentText(test.indentText)
+		w.Reset()
+		want, err := w.String(test.s)
+		if err != nil {
+			t.Errorf("%d: String: unexpected error: %q", i, err)
+			continue
+		}
+		w.Reset()
+		got, err := w.Runes([]rune(test.s))
+		if err != nil {
+			t.Errorf("%d: Runes: unexpected error: %q", i, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%d: got %q want %q", i, string(got), want)
+		}
+	}
+}
+
+func TestOnToken(t *testing.T) {
+	w := New()
+	w.Length = 20
+	want, err := w.String(gpl20)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+		return
+	}
+	kinds := map[TokenKind]int{}
+	var words []string
+	w.OnToken(func(kind TokenKind, value string) {
+		kinds[kind]++
+		if kind == TextToken {
+			words = append(words, value)
+		}
+	})
+	w.Reset()
+	got, err := w.String(gpl20)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+		return
+	}
+	if got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	// the hyphen in and/or is a token, so the words are split on it too.
+	fields := strings.FieldsFunc(gpl20, func(r rune) bool {
+		return r == ' ' || r == '\n' || r == '-'
+	})
+	if kinds[TextToken] != len(fields) {
+		t.Errorf("text tokens: got %d want %d", kinds[TextToken], len(fields))
+	}
+	for i := range fields {
+		if i >= len(words) {
+			break
+		}
+		if words[i] != fields[i] {
+			t.Errorf("%d: got %q want %q", i, words[i], fields[i])
+		}
+	}
+	if kinds[NewlineToken] != strings.Count(gpl20, "\n") {
+		t.Errorf("newline tokens: got %d want %d", kinds[NewlineToken], strings.Count(gpl20, "\n"))
+	}
+	if kinds[HyphenToken] != strings.Count(gpl20, "-") {
+		t.Errorf("hyphen tokens: got %d want %d", kinds[HyphenToken], strings.Count(gpl20, "-"))
+	}
+}
+
+func TestTokenKindStringer(t *testing.T) {
+	tests := []struct {
+		kind     TokenKind
+		expected string
+	}{
+		{TokenKind(-1), "invalid: -1 token kind"},
+		{TextToken, "text"},
+		{SpaceToken, "space"},
+		{HyphenToken, "hyphen"},
+		{NewlineToken, "newline"},
+		{TabToken, "tab"},
+	}
+	for _, test := range tests {
+		s := test.kind.String()
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", test.kind, s, test.expected)
+		}
+	}
+}
+
+func TestMongolianVowelSeparator(t *testing.T) {
+	tests := []struct {
+		breaks   bool
+		expected string
+	}{
+		{false, "Reality\nis\u180Efrequently\ninaccurate."},
+		{true, "Reality is\nfrequently\ninaccurate."},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.MongolianVowelSeparatorBreaks = test.breaks
+		s, err := w.String("Reality is\u180Efrequently inaccurate.")
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestNoBreakDelimiters(t *testing.T) {
+	tests := []struct {
+		open     rune
+		close    rune
+		s        string
+		expected string
+	}{
+		{0, 0, "Reality is [frequently inaccurate] but one is never alone.", "Reality is\n[frequently\ninaccurate] but one\nis never alone."},
+		{'[', ']', "Reality is [frequently inaccurate] but one is never alone.", "Reality is\nfrequently inaccurate\nbut one is never\nalone."},
+		{'[', ']', "Space is [mind-bogglingly big], really.", "Space is\nmind-bogglingly big,\nreally."},
+		{'`', '`', "Reality is `frequently inaccurate` but `one is`\n`never` alone.", "Reality is\nfrequently inaccurate\nbut one is\nnever alone."},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.NoBreakDelimiters(test.open, test.close)
+		s, err := w.String(test.s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestRuneWidth(t *testing.T) {
+	wide := func(r rune) int {
+		if r >= '가' && r <= '힣' { // hangul syllables
+			return 2
+		}
+		return 1
+	}
+	w := New()
+	w.Length = 20
+	w.TabSize(4)
+	w.RuneWidth(wide)
+	s, err := w.String("못 알아 듣겠어요 전혀 모르겠어요")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+		return
+	}
+	expected := "못 알아 듣겠어요\n전혀 모르겠어요"
+	if s != expected {
+		t.Errorf("got %q want %q", s, expected)
+	}
+	w.Reset()
+	w.IndentText("\t")
+	s, err = w.String("못\t알아\t듣겠어요\t전혀\t모르겠어요")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+		return
+	}
+	expected = "못\t알아\n\t듣겠어요\n\t전혀\n\t모르겠어요"
+	if s != expected {
+		t.Errorf("got %q want %q", s, expected)
+	}
+}
+
+var errShortDst = errors.New("short destination buffer")
+
+// composer composes e and a combining acute accent, a simplified version of
+// norm.NFC.
+type composer struct{}
+
+func (composer) Reset() {}
+
+func (composer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	s := strings.Replace(string(src), "e\u0301", "\u00e9", -1)
+	if len(dst) < len(s) {
+		return 0, 0, errShortDst
+	}
+	return copy(dst, s), len(src), nil
+}
+
+// upper upper cases ASCII; it needs dst to be at least twice as long as src to
+// test dst growth.
+type upper struct{}
+
+func (upper) Reset() {}
+
+func (upper) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if len(dst) < 2*len(src) {
+		return 0, 0, errShortDst
+	}
+	return copy(dst, strings.ToUpper(string(src))), len(src), nil
+}
+
+type failer struct{}
+
+func (failer) Reset() {}
+
+func (failer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	return 0, 0, errors.New("failed")
+}
+
+func TestWrapTransform(t *testing.T) {
+	tests := []struct {
+		s           string
+		transformer Transformer
+		expected    string
+		err         string
+	}{
+		{"Cafe\u0301 cafe\u0301 cafe\u0301", nil, "Cafe\u0301 cafe\u0301\ncafe\u0301", ""},
+		{"Cafe\u0301 cafe\u0301 cafe\u0301", composer{}, "Caf\u00e9 caf\u00e9 caf\u00e9", ""},
+		{"Cafe\u0301-cafe\u0301 cafe\u0301", composer{}, "Caf\u00e9-caf\u00e9 caf\u00e9", ""},
+		{"Reality is frequently inaccurate.", upper{}, "REALITY IS\nFREQUENTLY\nINACCURATE.", ""},
+		{"Reality is frequently inaccurate.", failer{}, "", "failed"},
+	}
+	w := New()
+	w.Length = 15
+	for i, test := range tests {
+		var (
+			s   string
+			err error
+		)
+		if test.transformer == nil {
+			w.Reset()
+			s, err = w.String(test.s)
+		} else {
+			s, err = w.WrapTransform(test.s, test.transformer)
+		}
+		if err != nil {
+			if err.Error() != test.err {
+				t.Errorf("%d: got error %q want %q", i, err, test.err)
+			}
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("%d: got no error want %q", i, test.err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestBlockLinePrefix(t *testing.T) {
+	s := "Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.\n\nI mean, you may think it's a long way down the road to the chemist's.\n"
+	tests := []struct {
+		prefix      string
+		indentText  string
+		prefixBlank bool
+		expected    string
+	}{
+		{"", "", false, "/*\nSpace is big. You just won't believe\nhow vastly, hugely, mind-bogglingly big\nit is.\n\nI mean, you may think it's a long way\ndown the road to the chemist's.\n*/\n"},
+		{"", "  ", false, "/*\n  Space is big. You just won't believe\n  how vastly, hugely, mind-bogglingly\n  big it is.\n\n  I mean, you may think it's a long way\n  down the road to the chemist's.\n*/\n"},
+		{"", "  ", true, "/*\n  Space is big. You just won't believe\n  how vastly, hugely, mind-bogglingly\n  big it is.\n\n  I mean, you may think it's a long way\n  down the road to the chemist's.\n*/\n"},
+		{" * ", "", false, "/*\n * Space is big. You just won't believe\n * how vastly, hugely, mind-bogglingly\n * big it is.\n\n * I mean, you may think it's a long\n * way down the road to the chemist's.\n*/\n"},
+		{" * ", "", true, "/*\n * Space is big. You just won't believe\n * how vastly, hugely, mind-bogglingly\n * big it is.\n *\n * I mean, you may think it's a long\n * way down the road to the chemist's.\n*/\n"},
+		{"* ", "\t", true, "/*\n\t* Space is big. You just won't\n\t* believe how vastly, hugely,\n\t* mind-bogglingly big it is.\n\t*\n\t* I mean, you may think it's a\n\t* long way down the road to the\n\t* chemist's.\n*/\n"},
+	}
+	w := New()
+	w.CommentStyle = CComment
+	w.Length = 40
+	for i, test := range tests {
+		w.Reset()
+		w.BlockLinePrefix(test.prefix)
+		w.IndentText(test.indentText)
+		w.PrefixBlankBlockLines = test.prefixBlank
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestStrictWidth(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		s        string
+		strict   bool
+		expected string
+		err      *WrapError
+	}{
+		{"Reality is " + long + " inaccurate.", false, "Reality is\n" + long + "\ninaccurate.", nil},
+		{"Reality is " + long + " inaccurate.", true, "", &WrapError{Line: 2, Width: 100, Length: 20, Text: long, MinLength: 100}},
+		{long, true, "", &WrapError{Line: 1, Width: 100, Length: 20, Text: long, MinLength: 100}},
+		{"Reality is frequently inaccurate.\n12345678901234567890 x", true, "Reality is\nfrequently\ninaccurate.\n12345678901234567890\nx", nil},
+		{"Reality is frequently inaccurate.\n123456789012345678901 x", true, "", &WrapError{Line: 4, Width: 21, Length: 20, Text: "123456789012345678901", MinLength: 21}},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.StrictWidth = test.strict
+		s, err := w.String(test.s)
+		if err != nil {
+			if test.err == nil {
+				t.Errorf("%d: unexpected error: %q", i, err)
+				continue
+			}
+			e, ok := err.(*WrapError)
+			if !ok {
+				t.Errorf("%d: got %T want *WrapError", i, err)
+				continue
+			}
+			if *e != *test.err {
+				t.Errorf("%d: got %#v want %#v", i, *e, *test.err)
+			}
+			continue
+		}
+		if test.err != nil {
+			t.Errorf("%d: got no error want %q", i, test.err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestLeadingSpace(t *testing.T) {
+	s := "Reality is\n frequently\n    inaccurate. One is never alone\n\twith a rubber duck."
+	tests := []struct {
+		leading  LeadingSpace
+		expected string
+	}{
+		{ElideLeadingSpace, "Reality is\nfrequently\ninaccurate. One is\nnever alone\nwith a rubber duck."},
+		{KeepLeadingSpace, "Reality is\n frequently\n    inaccurate. One\nis never alone\n\twith a rubber\nduck."},
+		{CollapseLeadingSpace, "Reality is\n frequently\n inaccurate. One is\nnever alone\n with a rubber\nduck."},
+	}
+	w := New()
+	w.Length = 20
+	w.TabSize(4)
+	for i, test := range tests {
+		w.Reset()
+		w.LeadingSpace = test.leading
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestLeadingSpaceStringer(t *testing.T) {
+	tests := []struct {
+		leading  LeadingSpace
+		expected string
+	}{
+		{LeadingSpace(-1), "invalid: -1 leading space"},
+		{ElideLeadingSpace, "elide"},
+		{KeepLeadingSpace, "keep"},
+		{CollapseLeadingSpace, "collapse"},
+	}
+	for _, test := range tests {
+		s := test.leading.String()
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", test.leading, s, test.expected)
+		}
+	}
+}
+
+func TestCommentStyleForFile(t *testing.T) {
+	tests := []struct {
+		filename string
+		style    CommentStyle
+	}{
+		{"", NoComment},
+		{"README", NoComment},
+		{"notes.txt", NoComment},
+		{"main.go", CPPComment},
+		{"/src/linewrap/linewrap_test.go", CPPComment},
+		{"lib.cpp", CPPComment},
+		{"Main.java", CPPComment},
+		{"lib.c", CComment},
+		{"lib.h", CComment},
+		{"build.sh", ShellComment},
+		{"setup.py", ShellComment},
+		{"Rakefile.rb", ShellComment},
+		{"Makefile", ShellComment},
+		{"schema.sql", SQLComment},
+		{"SCHEMA.SQL", SQLComment},
+		{"init.lisp", LispComment},
+		{"init.el", LispComment},
+	}
+	for _, test := range tests {
+		c := CommentStyleForFile(test.filename)
+		if c != test.style {
+			t.Errorf("%q: got %s want %s", test.filename, c, test.style)
+		}
+	}
+}
+
+func TestLineCommentStyles(t *testing.T) {
+	s := "Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.\n\nI mean, you may think it's a long way down the road to the chemist's."
+	tests := []struct {
+		style    CommentStyle
+		expected string
+	}{
+		{SQLComment, "-- Space is big. You just won't believe\n-- how vastly, hugely, mind-bogglingly\n-- big it is.\n--\n-- I mean, you may think it's a long\n-- way down the road to the chemist's."},
+		{LispComment, ";; Space is big. You just won't believe\n;; how vastly, hugely, mind-bogglingly\n;; big it is.\n;;\n;; I mean, you may think it's a long\n;; way down the road to the chemist's."},
+	}
+	w := New()
+	w.Length = 40
+	for _, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %q", test.style, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%s: got %q want %q", test.style, c, test.expected)
+		}
+	}
+}
+
+func TestAlignUnder(t *testing.T) {
+	s := "Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is."
+	tests := []struct {
+		label    string
+		expected string
+	}{
+		{"", "Space is big. You just won't believe\nhow vastly, hugely, mind-bogglingly big\nit is."},
+		{"Description: ", "Description: Space is big. You just\n             won't believe how vastly,\n             hugely, mind-bogglingly\n             big it is."},
+		{"Описание: ", "Описание: Space is big. You just won't\n          believe how vastly, hugely,\n          mind-bogglingly big it is."},
+	}
+	w := New()
+	w.Length = 40
+	for i, test := range tests {
+		w.Reset()
+		w.AlignUnder(test.label)
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+	// the lines of a line comment are aligned after the comment prefix.
+	w.Reset()
+	w.CommentStyle = CPPComment
+	w.AlignUnder("Description: ")
+	c, err := w.String(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	expected := "// Description: Space is big. You just\n//              won't believe how\n//              vastly, hugely, mind-\n//              bogglingly big it is."
+	if c != expected {
+		t.Errorf("got %q want %q", c, expected)
+	}
+}
+
+func TestFillExact(t *testing.T) {
+	s := "Reality is frequently inaccurate. One is never alone with a rubber duck."
+	tests := []struct {
+		length    int
+		fillExact bool
+		expected  string
+	}{
+		{33, false, "Reality is frequently\ninaccurate. One is never alone\nwith a rubber duck."},
+		{33, true, "Reality is frequently inaccurate.\nOne is never alone with a rubber\nduck."},
+		{34, false, "Reality is frequently inaccurate.\nOne is never alone with a rubber\nduck."},
+		{34, true, "Reality is frequently inaccurate.\nOne is never alone with a rubber\nduck."},
+		{32, true, "Reality is frequently\ninaccurate. One is never alone\nwith a rubber duck."},
+	}
+	w := New()
+	for i, test := range tests {
+		w.Reset()
+		w.Length = test.length
+		w.FillExact = test.fillExact
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+		for j, line := range strings.Split(c, "\n") {
+			n := len([]rune(line))
+			if n > test.length || (!test.fillExact && n == test.length) {
+				t.Errorf("%d:%d: line is %d chars, length %d", i, j, n, test.length)
+			}
+		}
+	}
+}
+
+func TestFigureSpaceNoBreak(t *testing.T) {
+	s := "The distance is 1\u2007000\u2007000 km."
+	tests := []struct {
+		noBreak  bool
+		expected string
+	}{
+		{false, "The distance is 1\u2007000\n000 km."},
+		{true, "The distance is\n1\u2007000\u2007000 km."},
+	}
+	w := New()
+	w.Length = 22
+	for i, test := range tests {
+		w.Reset()
+		w.FigureSpaceNoBreak = test.noBreak
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestNarrowNoBreakSpace(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"The distance is 1\u202F000\u202F000 km.", "The distance is\n1\u202F000\u202F000 km."},
+		{"The angle is 45\u202F\u00B0 or so.", "The angle is 45\u202F\u00B0 or\nso."},
+		{"\u202Fleading and trailing\u202F", "\u202Fleading and\ntrailing"},
+	}
+	w := New()
+	w.Length = 22
+	for i, test := range tests {
+		w.Reset()
+		c, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestASCIISpaceOnly(t *testing.T) {
+	tests := []struct {
+		value     string
+		asciiOnly bool
+		expected  string
+	}{
+		{"Reality is\u2001frequently inaccurate.", false, "Reality is\nfrequently\ninaccurate."},
+		{"Reality is\u2001frequently inaccurate.", true, "Reality\nis\u2001frequently\ninaccurate."},
+		{"Reality is\u200bfrequently inaccurate.", false, "Reality is\nfrequently\ninaccurate."},
+		{"Reality is\u200bfrequently inaccurate.", true, "Reality\nis\u200bfrequently\ninaccurate."},
+		{"Reality is\u3000frequently inaccurate.", true, "Reality\nis\u3000frequently\ninaccurate."},
+		{"Reality is\tfrequently inaccurate.", true, "Reality is\nfrequently\ninaccurate."},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.ASCIISpaceOnly = test.asciiOnly
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestStripZeroWidthSpace(t *testing.T) {
+	tests := []struct {
+		value    string
+		strip    bool
+		expected string
+	}{
+		{"Reality is\u200bfrequently inaccurate.", false, "Reality is\nfrequently\ninaccurate."},
+		{"Reality is\u200bfrequently inaccurate.", true, "Reality is\nfrequently\ninaccurate."},
+		// zero width spaces don't count against Length, whether or not they
+		// are stripped
+		{"one\u200btwo\u200bthree\u200bfour five\u200bsix", false, "one\u200btwo\u200bthree\nfour five\nsix"},
+		{"one\u200btwo\u200bthree\u200bfour five\u200bsix", true, "onetwothree\nfour five\nsix"},
+		{"a \u200b b", true, "a  b"},
+	}
+	w := New()
+	w.Length = 12
+	for i, test := range tests {
+		w.Reset()
+		w.StripZeroWidthSpace = test.strip
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		if test.strip && strings.Contains(s, "\u200b") {
+			t.Errorf("%d: zero width space in output: %q", i, s)
+		}
+	}
+}
+
+func TestBreakAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		chars    string
+		expected string
+	}{
+		{"list:alpha,beta,1,000,000,gamma;3.14;delta.", "", "list:alpha,beta,1,000,000,gamma;3.14;delta."},
+		{"list:alpha,beta,1,000,000,gamma;3.14;delta.", ",.;", "list:alpha,\nbeta,\n1,000,000,\ngamma;3.14;\ndelta."},
+		// numbers are kept whole
+		{"1,000,000 3.14", ",.", "1,000,000\n3.14"},
+		{"Use 3.14, not 3.", ",.", "Use 3.14,\nnot 3."},
+	}
+	w := New()
+	w.Length = 12
+	for i, test := range tests {
+		w.Reset()
+		w.BreakAfter(test.chars)
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestEnDashRangeNoBreak(t *testing.T) {
+	tests := []struct {
+		value    string
+		noBreak  bool
+		expected string
+	}{
+		{"see pp. 10\u201320", false, "see pp. 10\u2013\n20"},
+		{"see pp. 10\u201320", true, "see pp.\n10\u201320"},
+		{"open Mon\u2013Fri", false, "open Mon\u2013\nFri"},
+		{"open Mon\u2013Fri", true, "open\nMon\u2013Fri"},
+		// a spaced en dash isn't a range
+		{"one word \u2013 word", false, "one word \u2013\nword"},
+		{"one word \u2013 word", true, "one word \u2013\nword"},
+	}
+	w := New()
+	w.Length = 12
+	for i, test := range tests {
+		w.Reset()
+		w.EnDashRangeNoBreak = test.noBreak
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestMinWordsPerLine(t *testing.T) {
+	value := "one two three four extraordinarily lengthy words"
+	tests := []struct {
+		value    string
+		minWords int
+		style    CommentStyle
+		expected string
+	}{
+		{value, 0, NoComment, "one two three four\nextraordinarily\nlengthy words"},
+		{value, 2, NoComment, "one two three\nfour extraordinarily\nlengthy words"},
+		{value, 2, CPPComment, "// one two\n// three four\n// extraordinarily\n// lengthy words"},
+		// the prior line can't give up a word without having too few
+		{value, 3, NoComment, "one two three\nfour extraordinarily\nlengthy words"},
+		// only lines in the same paragraph are balanced
+		{"one two three four\nextraordinarily lengthy words", 2, NoComment, "one two three four\nextraordinarily\nlengthy words"},
+		// a no-break space isn't between words; a multibyte space is
+		{"one two three 5\u00a0kg extraordinarily lengthy words", 2, NoComment, "one two three\n5\u00a0kg extraordinarily\nlengthy words"},
+		{"one two three\u2003four extraordinarily lengthy words", 2, NoComment, "one two three\nfour extraordinarily\nlengthy words"},
+	}
+	w := New()
+	w.Length = 21
+	for i, test := range tests {
+		w.Reset()
+		w.MinWordsPerLine = test.minWords
+		w.CommentStyle = test.style
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestSoftHyphenOnly(t *testing.T) {
+	value := "The word in\u00adcom\u00adpre\u00adhen\u00adsi\u00adbil\u00adi\u00adties is long, self-evident though."
+	tests := []struct {
+		length   int
+		expected string
+	}{
+		{14, "The word in-\ncomprehensi-\nbilities is\nlong,\nself-evident\nthough."},
+		{16, "The word incom-\nprehensibili-\nties is long,\nself-evident\nthough."},
+		{20, "The word incompre-\nhensibilities is\nlong, self-evident\nthough."},
+		{24, "The word incomprehensi-\nbilities is long,\nself-evident though."},
+		{80, "The word incomprehensibilities is long, self-evident though."},
+	}
+	w := New()
+	w.SoftHyphenOnly = true
+	for i, test := range tests {
+		w.Reset()
+		w.Length = test.length
+		s, err := w.String(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestBreakAfterSlash(t *testing.T) {
+	value := "Copy /usr/local/share/very/long/path/file.txt from https://example.com/very/long/path/file.txt now."
+	tests := []struct {
+		length   int
+		breaks   bool
+		expected string
+	}{
+		{16, false, "Copy\n/usr/local/share/very/long/path/file.txt\nfrom\nhttps://example.com/very/long/path/file.txt\nnow."},
+		{16, true, "Copy /usr/\nlocal/share/\nvery/long/path/\nfile.txt from\nhttps://example.com/very/long/path/file.txt\nnow."},
+		{24, true, "Copy /usr/local/share/\nvery/long/path/file.txt\nfrom\nhttps://example.com/very/long/path/file.txt\nnow."},
+		{80, true, "Copy /usr/local/share/very/long/path/file.txt from\nhttps://example.com/very/long/path/file.txt now."},
+	}
+	w := New()
+	for i, test := range tests {
+		w.Reset()
+		w.Length = test.length
+		w.BreakAfterSlash = test.breaks
+		s, err := w.String(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestNoBreakJoiner(t *testing.T) {
+	tests := []struct {
+		joiner   rune
+		length   int
+		value    string
+		expected string
+	}{
+		{'\uFEFF', 17, "the weight: 500\uFEFF kg", "the weight:\n500\uFEFF kg"},
+		{'\uFEFF', 17, "the weight: 500\u2060 kg", "the weight:\n500\u2060 kg"},
+		{'\uFEFF', 9, "a well-\u2060known fact", "a\nwell-\u2060known\nfact"},
+		{'\u2060', 17, "the weight: 500\uFEFF kg", "the weight: 500\uFEFF\nkg"},
+		{'\u2060', 17, "the weight: 500\u2060 kg", "the weight:\n500\u2060 kg"},
+		{'\u2060', 9, "a well-\u2060known fact", "a\nwell-\u2060known\nfact"},
+		{0, 17, "the weight: 500\uFEFF kg", "the weight: 500\uFEFF\nkg"},
+		{0, 17, "the weight: 500\u2060 kg", "the weight:\n500\u2060 kg"},
+	}
+	w := New()
+	for i, test := range tests {
+		w.Reset()
+		w.Length = test.length
+		w.NoBreakJoiner(test.joiner)
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestKeepTogether(t *testing.T) {
+	// keep a number with its unit
+	unit := func(left, right string) bool {
+		if left[len(left)-1] < '0' || left[len(left)-1] > '9' {
+			return false
+		}
+		switch strings.TrimRight(right, ".,") {
+		case "kg", "km", "m":
+			return true
+		}
+		return false
+	}
+	s := "The crate weighs 5 kg and was shipped 120 km by truck to a 3 m shelf."
+	tests := []struct {
+		length   int
+		expected string
+	}{
+		{16, "The crate\nweighs 5 kg and\nwas shipped\n120 km by truck\nto a 3 m shelf."},
+		{20, "The crate weighs\n5 kg and was\nshipped 120 km by\ntruck to a 3 m\nshelf."},
+		{22, "The crate weighs 5 kg\nand was shipped\n120 km by truck to a\n3 m shelf."},
+		{25, "The crate weighs 5 kg\nand was shipped 120 km\nby truck to a 3 m shelf."},
+	}
+	w := New()
+	w.KeepTogether(unit)
+	for _, test := range tests {
+		w.Reset()
+		w.Length = test.length
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", test.length, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q; want %q", test.length, c, test.expected)
+		}
+	}
+}
+
+// Tabs are the same width in the indent and in the text.
+func TestTabWidth(t *testing.T) {
+	s := "\tone two\tthree four five\tsix seven eight"
+	tests := []struct {
+		tabSize  int
+		expected string
+	}{
+		{2, "\tone two\tthree\n\tfour five\tsix\n\tseven eight"},
+		{4, "\tone two\n\tthree four five\n\tsix seven eight"},
+		{8, "\tone two\n\tthree four\n\tfive\n\tsix seven\n\teight"},
+	}
+	w := New()
+	w.Length = 20
+	w.IndentText("\t")
+	for _, test := range tests {
+		w.Reset()
+		w.TabSize(test.tabSize)
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", test.tabSize, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q; want %q", test.tabSize, c, test.expected)
+		}
+	}
+}
+
+func TestWrapErrorMinLength(t *testing.T) {
+	s := "Reality is frequently inaccurate.\n123456789012345678901 x 12345678901234567890123 y"
+	tests := []struct {
+		style     CommentStyle
+		indent    string
+		minLength int
+	}{
+		{NoComment, "", 23},
+		{NoComment, "\t", 31},
+		{CPPComment, "\t", 26},
+		{CComment, "  ", 25},
+	}
+	w := New()
+	w.Length = 20
+	w.StrictWidth = true
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		w.IndentText(test.indent)
+		_, err := w.String(s)
+		e, ok := err.(*WrapError)
+		if !ok {
+			t.Errorf("%d: got %#v want a *WrapError", i, err)
+			continue
+		}
+		if e.MinLength != test.minLength {
+			t.Errorf("%d: got %d want %d", i, e.MinLength, test.minLength)
+			continue
+		}
+		// the input can be wrapped to the suggested Length
+		c, err := w.WrapToWidth(s, e.MinLength)
+		if err != nil {
+			t.Errorf("%d: Length %d: unexpected error: %q: %q", i, e.MinLength, err, c)
+		}
+	}
+	err := &WrapError{Line: 4, Width: 21, Length: 20, Text: "123456789012345678901", MinLength: 23}
+	expected := `line 4: width 21 exceeds length 20: "123456789012345678901": minimum Length for this input is 23`
+	if err.Error() != expected {
+		t.Errorf("got %q want %q", err.Error(), expected)
+	}
+}
+
+func TestSoftBreakMarker(t *testing.T) {
+	s := "Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.\nI mean, you may think it's a long way down the road to the chemist's, but that's just peanuts to space."
+	tests := []struct {
+		marker string
+		length int
+	}{
+		{" ", 10},
+		{" ", 20},
+		{"\u200b", 10},
+		{"\u200b", 30},
+	}
+	for i, test := range tests {
+		w := New()
+		w.Length = test.length
+		w.SoftBreakMarker(test.marker)
+		wrapped, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if strings.Count(wrapped, test.marker+"\n") != strings.Count(wrapped, "\n")-1 {
+			t.Errorf("%d: expected every new line, except the hard break, to be marked: %q", i, wrapped)
+			continue
+		}
+		unwrapped := w.Unwrap(wrapped)
+		if unwrapped != s {
+			t.Errorf("%d: unwrap: got %q want %q", i, unwrapped, s)
+			continue
+		}
+		w.Reset()
+		rewrapped, err := w.String(unwrapped)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if rewrapped != wrapped {
+			t.Errorf("%d: rewrap: got %q want %q", i, rewrapped, wrapped)
+		}
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"", ""},
+		{"Space is big.", "Space is big."},
+		{"Space is\nbig.\n", "Space is big.\n"},
+		{"Space is big. You just\nwon't believe how vastly,\r\nhugely, mind-bogglingly\nbig it is.", "Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is."},
+		{"Space is big. You  \n  just won't believe\n\nhow vastly,\nhugely,\n\n\nbig it is.\n", "Space is big. You just won't believe\n\nhow vastly, hugely,\n\n\nbig it is.\n"},
+		{"\n\nSpace is\nbig.", "\n\nSpace is big."},
+		{"  Space is\n  big.", "  Space is big."},
+		// lines ending in a hyphen are joined without a space
+		{"you just won't believe how vastly, hugely, mind-\nbogglingly big it is.", "you just won't believe how vastly, hugely, mind-bogglingly big it is."},
+		{"you just won't believe how vastly, hugely, mind\u2010\nbogglingly big it is.", "you just won't believe how vastly, hugely, mind\u2010bogglingly big it is."},
+	}
+	for i, test := range tests {
+		s := Unwrap(test.s, false)
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestUnwrapDehyphenate(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"you just won't believe how vastly, hugely, mind-bog-\nglingly big it is.", "you just won't believe how vastly, hugely, mind-bogglingly big it is."},
+		{"you just won't believe how vastly, hugely, mind-bog\u2010\nglingly big it is.", "you just won't believe how vastly, hugely, mind-bogglingly big it is."},
+		{"you just won't believe how vastly, hugely, mind-bog\u00AD\nglingly big it is.", "you just won't believe how vastly, hugely, mind-bogglingly big it is."},
+		// a compound word that's found elsewhere keeps its hyphen
+		{"It is a well-known fact that it is well-\nknown.", "It is a well-known fact that it is well-known."},
+		{"It is a well-\nknown fact that it is well-known.", "It is a well-known fact that it is well-known."},
+		// the hyphen must be between lowercase letters
+		{"It is a well-\nKnown fact.", "It is a well-Known fact."},
+		{"It is 3-\n4 times as big.", "It is 3-4 times as big."},
+		{"It is big -\nvery big.", "It is big -very big."},
+		{"The en dash\u2013\nisn't a hyphen.", "The en dash\u2013isn't a hyphen."},
+	}
+	for i, test := range tests {
+		s := Unwrap(test.s, true)
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestIgnoreANSI(t *testing.T) {
+	s := "Space is big. \x1b[31mYou just won't believe how \x1b[1mvastly, hugely,\x1b[0m mind-bogglingly big it is."
+	tests := []struct {
+		style    CommentStyle
+		expected string
+	}{
+		{NoComment, "Space is big. \x1b[31mYou\x1b[0m\n\x1b[31mjust won't believe\x1b[0m\n\x1b[31mhow \x1b[1mvastly, hugely,\x1b[0m\nmind-bogglingly big\nit is."},
+		{CPPComment, "// Space is big.\n// \x1b[31mYou just won't\x1b[0m\n// \x1b[31mbelieve how\x1b[0m\n// \x1b[31m\x1b[1mvastly, hugely,\x1b[0m\n// mind-bogglingly\n// big it is."},
+	}
+	w := New()
+	w.Length = 20
+	w.IgnoreANSI = true
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+	// a clause break starts the new line with the SGR state at the break
+	w.Reset()
+	w.CommentStyle = NoComment
+	w.ClauseBreaks = true
+	c, err := w.String("Space is big, very \x1b[32mvastly big and green.")
+	if err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+	expected := "Space is big,\nvery \x1b[32mvastly big and\x1b[0m\n\x1b[32mgreen."
+	if c != expected {
+		t.Errorf("got %q want %q", c, expected)
+	}
+	// truncated output that ends within an SGR sequence ends with a reset.
+	w.ClauseBreaks = false
+	truncated := []struct {
+		value    string
+		maxLines int
+		expected string
+	}{
+		{"aaa \x1b[31mbbb ccc ddd eee fff\x1b[0m", 1, "aaa \x1b[31mbbb…\x1b[0m"},
+		{"aaa \x1b[31mbbb ccc ddd eee fff ggg hhh\x1b[0m", 2, "aaa \x1b[31mbbb ccc\x1b[0m\n\x1b[31mddd eee…\x1b[0m"},
+		{"aaa \x1b[31mbbb\x1b[0m ccc ddd eee fff", 1, "aaa \x1b[31mbbb\x1b[0m…"},
+		// an escape sequence isn't cut to make room for the ellipsis.
+		{"\x1b[31maaaaaaaaaaa\x1b[0m bbb", 1, "\x1b[31maaaaaaaaaa…\x1b[0m"},
+		// the lines past MaxLines are blank.
+		{"aaa \x1b[31mbbb\n\n\n", 1, "aaa \x1b[31mbbb\x1b[0m"},
+	}
+	w.Length = 12
+	for i, test := range truncated {
+		w.Reset()
+		w.MaxLines = test.maxLines
+		c, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("truncated %d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("truncated %d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestMinimizeDiff(t *testing.T) {
+	s := "Space is big. You just won't\nbelieve how vastly, hugely,\nmind-bogglingly big it is.\nI mean, you may think it's a\nlong way down the road to the\nchemist's, but that's just\npeanuts to space.\n\nListen...\nshort line here.\n"
+	tests := []struct {
+		old, new string
+		expected string
+	}{
+		{"", "", s},
+		{"You just", "You really just", "Space is big. You really just\nwon't\nbelieve how vastly, hugely,\nmind-bogglingly big it is.\nI mean, you may think it's a\nlong way down the road to the\nchemist's, but that's just\npeanuts to space.\n\nListen...\nshort line here.\n"},
+		{"hugely,", "hugely, so", "Space is big. You just won't\nbelieve how vastly, hugely,\nso mind-bogglingly big it is.\nI mean, you may think it's a\nlong way down the road to the\nchemist's, but that's just\npeanuts to space.\n\nListen...\nshort line here.\n"},
+	}
+	w := New()
+	w.Length = 30
+	for i, test := range tests {
+		edited := strings.Replace(s, test.old, test.new, 1)
+		w.Reset()
+		w.MinimizeDiff = false
+		greedy, err := w.String(Unwrap(edited, false))
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		w.Reset()
+		w.MinimizeDiff = true
+		c, err := w.String(edited)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+			continue
+		}
+		if changedLines(s, c) >= changedLines(s, greedy) {
+			t.Errorf("%d: got %d changed lines; greedy wrapping has %d", i, changedLines(s, c), changedLines(s, greedy))
+		}
+	}
+}
+
+func TestMinimizeDiffLeadingSpace(t *testing.T) {
+	s := "Some text:\n    indented line one\n    indented line two that is long enough to wrap\n\tand a tab"
+	tests := []struct {
+		leading  LeadingSpace
+		expected string
+	}{
+		// the leading whitespace is meaningful; the indented lines are kept as is
+		{KeepLeadingSpace, "Some text:\n    indented line one\n    indented line two that is\nlong enough to wrap\n\tand a tab"},
+		// the text is reflowed; the leading whitespace is elided
+		{ElideLeadingSpace, "Some text:\nindented line one\nindented line two that is\nlong enough to wrap and a tab"},
+	}
+	w := New()
+	w.Length = 30
+	w.MinimizeDiff = true
+	for i, test := range tests {
+		w.Reset()
+		w.LeadingSpace = test.leading
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+// changedLines returns the number of lines in b that aren't in a.
+func changedLines(a, b string) int {
+	lines := make(map[string]int)
+	for _, line := range strings.Split(a, "\n") {
+		lines[line]++
+	}
+	var n int
+	for _, line := range strings.Split(b, "\n") {
+		if lines[line] == 0 {
+			n++
+			continue
+		}
+		lines[line]--
+	}
+	return n
+}
+
+func TestWrapFieldComment(t *testing.T) {
+	tests := []struct {
+		code     string
+		comment  string
+		expected string
+	}{
+		{"Name string", "the name", "Name string // the name"},
+		{"Name string", "", "Name string"},
+		{
+			"\tLength int", "Length is the max length of a line, in chars; if 0, lines aren't wrapped.",
+			"\tLength int // Length is the max length of a\n\t           // line, in chars; if 0, lines\n\t           // aren't wrapped.",
+		},
+		{
+			"Tabs bool", "Tabs determines whether the output is indented with tabs.",
+			"Tabs bool // Tabs determines whether the output\n          // is indented with tabs.",
+		},
+	}
+	w := New()
+	w.Length = 50
+	w.TabSize(4)
+	for i, test := range tests {
+		s, err := w.WrapFieldComment(test.code, test.comment)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestWrapNumbered(t *testing.T) {
+	var items []string
+	for _, n := range []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten", "eleven", "twelve"} {
+		items = append(items, "Step "+n+" is to read the instructions again.")
+	}
+	expected := "1. Step one is to read\n   the instructions\n   again.\n2. Step two is to read\n   the instructions\n   again.\n3. Step three is to\n   read the\n   instructions again.\n4. Step four is to read\n   the instructions\n   again.\n5. Step five is to read\n   the instructions\n   again.\n6. Step six is to read\n   the instructions\n   again.\n7. Step seven is to\n   read the\n   instructions again.\n8. Step eight is to\n   read the\n   instructions again.\n9. Step nine is to read\n   the instructions\n   again.\n10. Step ten is to read\n    the instructions\n    again.\n11. Step eleven is to\n    read the\n    instructions again.\n12. Step twelve is to\n    read the\n    instructions again."
+	w := New()
+	w.Length = 24
+	s, err := w.WrapNumbered(items)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if s != expected {
+		t.Errorf("got %q want %q", s, expected)
+	}
+	if w.label != nil || w.indentText != nil {
+		t.Errorf("expected the Wrapper to be unchanged; got label %q and indentText %q", w.label, w.indentText)
+	}
+	// a commented item's lines hang under its text, after the comment prefix.
+	w.Length = 14
+	w.CommentStyle = CPPComment
+	s, err = w.WrapNumbered([]string{"aaa bbb ccc", "ddd"})
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if expected := "// 1. aaa bbb\n//    ccc\n// 2. ddd"; s != expected {
+		t.Errorf("got %q want %q", s, expected)
+	}
+}
+
+func TestWrapCSmart(t *testing.T) {
+	tests := []struct {
+		s        string
+		block    bool
+		expected string
+	}{
+		{"", false, ""},
+		{" \t\n ", false, ""},
+		{" \t\n ", true, ""},
+		{"Space is big.", false, "/* Space is big. */"},
+		{"Space is big.", true, "/* Space is big. */"},
+		{"1234567890123456789012", false, "/* 1234567890123456789012 */"},
+		{"123456789012345678901234", false, "// 123456789012345678901234"},
+		{"Space is big. You just won't believe how vastly big it is.", false, "// Space is big. You just\n// won't believe how vastly\n// big it is."},
+		{"Space is big. You just won't believe how vastly big it is.", true, "/*\nSpace is big. You just won't\nbelieve how vastly big it is.\n*/\n"},
+		{"Space is big.\nReally big.", false, "// Space is big.\n// Really big."},
+	}
+	w := New()
+	w.Length = 30
+	for i, test := range tests {
+		w.CSmartBlock = test.block
+		s, err := w.WrapCSmart(test.s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+	if w.CommentStyle != NoComment {
+		t.Errorf("expected the Wrapper's CommentStyle to be unchanged; got %s", w.CommentStyle)
+	}
+}
+
+func TestLastColumn(t *testing.T) {
+	tests := []struct {
+		s      string
+		style  CommentStyle
+		column int
+	}{
+		{"", NoComment, 0},
+		{"Space is big.", NoComment, 13},
+		{"Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.", NoComment, 6},
+		{"Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.", CPPComment, 13},
+		{"Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.", CComment, 0},
+		{"Space is big.\n", NoComment, 0},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		_, err := w.String(test.s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if w.LastColumn() != test.column {
+			t.Errorf("%d: got %d want %d", i, w.LastColumn(), test.column)
+		}
+	}
+}
+
+func TestMaxLines(t *testing.T) {
+	s := "Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is."
+	tests := []struct {
+		s        string
+		maxLines int
+		ellipsis string
+		style    CommentStyle
+		expected string
+	}{
+		{s, 0, "", NoComment, "Space is big. You\njust won't believe\nhow vastly, hugely,\nmind-bogglingly big\nit is."},
+		{s, 2, "", NoComment, "Space is big. You\njust won't believe…"},
+		{s, 2, "...", NoComment, "Space is big. You\njust won't..."},
+		{s, 1, "...", NoComment, "Space is big...."},
+		{s, 4, "", NoComment, "Space is big. You\njust won't believe\nhow vastly, hugely,\nmind-bogglingly…"},
+		{s, 3, "...", CPPComment, "// Space is big.\n// You just won't\n// believe how..."},
+		{s, 2, "", CComment, "/*\nSpace is big. You\njust won't believe…\n*/\n"},
+		// the ellipsis is only added if the output was truncated
+		{s, 5, "", NoComment, "Space is big. You\njust won't believe\nhow vastly, hugely,\nmind-bogglingly big\nit is."},
+		{"Space is big.\nReally big.\n\n\n", 2, "", NoComment, "Space is big.\nReally big."},
+		{"Space is big.\nReally big.\n\nReally.", 2, "", NoComment, "Space is big.\nReally big.…"},
+	}
+	for i, test := range tests {
+		w := New()
+		w.Length = 20
+		w.MaxLines = test.maxLines
+		w.CommentStyle = test.style
+		if test.ellipsis != "" {
+			w.Ellipsis(test.ellipsis)
+		}
+		c, err := w.String(test.s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+	// the input after the last line isn't read, but the lexer is finished.
+	w := New()
+	w.Length = 20
+	w.MaxLines = 1
+	n := leaks(100, func() {
+		w.Reset()
+		w.String(s)
+		w.Clone().String(s)
+	})
+	if n > 0 {
+		t.Errorf("%d go routines were leaked", n)
+	}
+}
+
+func TestWrapFrom(t *testing.T) {
+	// the head is wrapped narrower than Length; it is left as is.
+	head := "Space is big.\nYou just won't\nbelieve how\nvastly, hugely, "
+	tail := "mind-bogglingly big it is. I mean, you may think it's a long way down the road to the chemist's."
+	s := head + tail
+	tests := []struct {
+		offset   int
+		style    CommentStyle
+		expected string
+		err      string
+	}{
+		{0, NoComment, "Space is big.\nYou just won't\nbelieve how\nvastly, hugely, mind-bogglingly\nbig it is. I mean, you may\nthink it's a long way down the\nroad to the chemist's.", ""},
+		{len(head), NoComment, "Space is big.\nYou just won't\nbelieve how\nvastly, hugely, mind-bogglingly\nbig it is. I mean, you may\nthink it's a long way down the\nroad to the chemist's.", ""},
+		{len(head) - 16, NoComment, "Space is big.\nYou just won't\nbelieve how\nvastly, hugely, mind-bogglingly\nbig it is. I mean, you may\nthink it's a long way down the\nroad to the chemist's.", ""},
+		{len(head) + 5, NoComment, "Space is big.\nYou just won't\nbelieve how\nvastly, hugely, mind-bogglingly\nbig it is. I mean, you may\nthink it's a long way down the\nroad to the chemist's.", ""},
+		// the offset is within a word; the word is wrapped as a whole
+		{len(head) + 8, NoComment, "Space is big.\nYou just won't\nbelieve how\nvastly, hugely, mind-bogglingly\nbig it is. I mean, you may\nthink it's a long way down the\nroad to the chemist's.", ""},
+		{len(s), NoComment, s, ""},
+		{-1, NoComment, "", "offset -1 out of range: the length of the string is 153"},
+		{len(s) + 1, NoComment, "", "offset 154 out of range: the length of the string is 153"},
+	}
+	w := New()
+	w.Length = 32
+	for i, test := range tests {
+		w.CommentStyle = test.style
+		c, err := w.WrapFrom(s, test.offset)
+		if err != nil {
+			if err.Error() != test.err {
+				t.Errorf("%d: got error %q want %q", i, err, test.err)
+			}
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("%d: got no error want %q", i, test.err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+	// the text after the offset is blank.
+	w.CommentStyle = NoComment
+	for i, test := range []struct {
+		s        string
+		offset   int
+		expected string
+	}{
+		{"aaa bbb\n", 7, "aaa bbb\n"},
+		{"aaa bbb   ", 7, "aaa bbb"},
+		{"aaa bbb \n\n  ", 8, "aaa bbb\n\n"},
+	} {
+		c, err := w.WrapFrom(test.s, test.offset)
+		if err != nil {
+			t.Errorf("blank %d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("blank %d: got %q want %q", i, c, test.expected)
+		}
+	}
+	_, err := w.WrapFrom("日本", 1)
+	if err == nil || err.Error() != "offset 1 is not at the start of a rune" {
+		t.Errorf("got %v want an offset is not at the start of a rune error", err)
+	}
+	w.Length = 8
+	c, err := w.WrapFrom("ab cdefgh ij", 5)
+	if err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+	if c != "ab\ncdefgh\nij" {
+		t.Errorf("got %q want %q", c, "ab\ncdefgh\nij")
+	}
+	w.Length = 32
+	// continuing line comments
+	w.CommentStyle = CPPComment
+	head = "// Space is big. You just\n// won't believe how vastly,\n// hugely, "
+	expected := "// Space is big. You just\n// won't believe how vastly,\n// hugely, mind-bogglingly big\n// it is. I mean, you may think\n// it's a long way down the\n// road to the chemist's."
+	for _, offset := range []int{len(head), len(head) + 2} {
+		c, err = w.WrapFrom(head+tail, offset)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", offset, err)
+		}
+		if c != expected {
+			t.Errorf("%d: got %q want %q", offset, c, expected)
+		}
+	}
+	w.Length = 12
+	head = "// aaa bbb\n// ccc ddd\n// eee fff"
+	c, err = w.WrapFrom(head+"ggg hhh", len(head))
+	if err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+	expected = "// aaa bbb\n// ccc ddd\n// eee\n// fffggg\n// hhh"
+	if c != expected {
+		t.Errorf("got %q want %q", c, expected)
+	}
+}
+
+func TestTrimTrailing(t *testing.T) {
+	tests := []struct {
+		s        string
+		length   int
+		indent   string
+		trim     bool
+		expected string
+	}{
+		{"Space is big.\u00a0 You just won't believe how vastly big it is.", 16, "", false, "Space is big.\u00a0\nYou just won't\nbelieve how\nvastly big it\nis."},
+		{"Space is big.\u00a0 You just won't believe how vastly big it is.", 16, "", true, "Space is big.\nYou just won't\nbelieve how\nvastly big it\nis."},
+		{"Space is big.\n\nYou just won't believe. \t", 20, "  ", false, "Space is big.\n\n  You just won't\n  believe. \t"},
+		{"Space is big.\n\nYou just won't believe. \t", 20, "  ", true, "Space is big.\n\n  You just won't\n  believe."},
+		{"Space is big.\u2003\t\nYou just won't believe.\u2003", 30, "", false, "Space is big.\nYou just won't believe.\u2003"},
+		{"Space is big.\u2003\t\nYou just won't believe.\u2003", 30, "", true, "Space is big.\nYou just won't believe."},
+	}
+	for i, test := range tests {
+		w := New()
+		w.Length = test.length
+		w.TrimTrailing = test.trim
+		w.IndentText(test.indent)
+		c, err := w.String(test.s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestWrapBetween(t *testing.T) {
+	tests := []struct {
+		prefix, body, suffix string
+		expected             string
+	}{
+		{"key = ", "short", " # note", "key = short # note"},
+		{"# key = ", "a long value that has to be wrapped because it is too long to fit", "  # note", "# key = a long value that has to be\n        wrapped because it is too long\n        to fit  # note"},
+		// the suffix doesn't fit on the last line
+		{"# key = ", "a long value that has to be wrapped because it's long", "  # note", "# key = a long value that has to be\n        wrapped because it's long\n        # note"},
+	}
+	w := New()
+	w.Length = 40
+	for i, test := range tests {
+		s, err := w.WrapBetween(test.prefix, test.body, test.suffix)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+	if w.label != nil || w.suffix != nil {
+		t.Errorf("expected the Wrapper to be unchanged; got label %q and suffix %q", w.label, w.suffix)
+	}
+}
+
+func TestLengthUnit(t *testing.T) {
+	tests := []struct {
+		unit     LengthUnit
+		length   int
+		value    string
+		expected string
+	}{
+		{Columns, 20, "안녕하세요 세계 여러분 반갑습니다 오늘은 좋은 날", "안녕하세요 세계 여러분 반갑습니다\n오늘은 좋은 날"},
+		{Runes, 20, "안녕하세요 세계 여러분 반갑습니다 오늘은 좋은 날", "안녕하세요 세계 여러분 반갑습니다\n오늘은 좋은 날"},
+		// each hangul syllable is 3 bytes
+		{Bytes, 20, "안녕하세요 세계 여러분 반갑습니다 오늘은 좋은 날", "안녕하세요\n세계 여러분\n반갑습니다\n오늘은 좋은\n날"},
+		{Columns, 12, "one\ttwo three four", "one\ntwo three\nfour"},
+		{Bytes, 12, "one\ttwo three four", "one\ttwo\nthree four"},
+		{Runes, 12, "one\ttwo three four", "one\ttwo\nthree four"},
+	}
+	for i, test := range tests {
+		w := New()
+		w.Length = test.length
+		w.LengthUnit = test.unit
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: %s: got %q want %q", i, test.unit, s, test.expected)
+		}
+		if test.unit != Bytes {
+			continue
+		}
+		for _, line := range strings.Split(s, "\n") {
+			if len(line) >= test.length {
+				t.Errorf("%d: %q is %d bytes; want less than %d", i, line, len(line), test.length)
+			}
+		}
+	}
+}
+
+func TestLengthUnitStringer(t *testing.T) {
+	tests := []struct {
+		unit     LengthUnit
+		expected string
+	}{
+		{LengthUnit(-1), "invalid: -1 length unit"},
+		{Columns, "columns"},
+		{Bytes, "bytes"},
+		{Runes, "runes"},
+	}
+	for _, test := range tests {
+		s := test.unit.String()
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", test.unit, s, test.expected)
+		}
+	}
+}
+
+func TestWrapBox(t *testing.T) {
+	tests := []struct {
+		corners, horizontal, vertical string
+		value                         string
+		expected                      string
+	}{
+		{"", "", "", "A box around some text that is long enough to wrap.", "+------------------------+\n| A box around some text |\n| that is long enough to |\n| wrap.                  |\n+------------------------+"},
+		{"┌┐└┘", "─", "│", "A box around some text that is long enough to wrap.", "┌────────────────────────┐\n│ A box around some text │\n│ that is long enough to │\n│ wrap.                  │\n└────────────────────────┘"},
+		{"*", "*", "*", "short", "*********\n* short *\n*********"},
+		{"", "", "", "", ""},
+		{"", "", "", " \t\n ", ""},
+	}
+	for i, test := range tests {
+		w := New()
+		w.Length = 30
+		w.CommentStyle = CPPComment
+		if test.corners != "" {
+			if err := w.BoxChars(test.corners, test.horizontal, test.vertical); err != nil {
+				t.Errorf("%d: unexpected error: %q", i, err)
+				continue
+			}
+		}
+		s, err := w.WrapBox(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		for _, line := range strings.Split(s, "\n") {
+			if n := len([]rune(line)); n >= w.Length {
+				t.Errorf("%d: %q is %d chars; want less than %d", i, line, n, w.Length)
+			}
+		}
+	}
+}
+
+func TestBoxChars(t *testing.T) {
+	tests := []struct {
+		corners, horizontal, vertical string
+		err                           string
+	}{
+		{"+", "-", "|", ""},
+		{"┌┐└┘", "─", "│", ""},
+		{"", "", "", "invalid box corners \"\": want 1 or 4 chars that are 1 column wide"},
+		{"++", "-", "|", "invalid box corners \"++\": want 1 or 4 chars that are 1 column wide"},
+		{"+", "", "|", "invalid box edge \"\": want a char that is 1 column wide"},
+		{"+", "-", "||", "invalid box edge \"||\": want a char that is 1 column wide"},
+		{"+", "━━", "|", "invalid box edge \"━━\": want a char that is 1 column wide"},
+		{"+", "\t", "|", "invalid box edge \"\\t\": want a char that is 1 column wide"},
+		{"+", "＝", "|", "invalid box edge \"＝\": want a char that is 1 column wide"},
+	}
+	for i, test := range tests {
+		w := New()
+		w.RuneWidth(func(r rune) int {
+			if r == '＝' {
+				return 2
+			}
+			return 1
+		})
+		err := w.BoxChars(test.corners, test.horizontal, test.vertical)
+		if err != nil {
+			if err.Error() != test.err {
+				t.Errorf("%d: got error %q want %q", i, err, test.err)
+			}
+			// the box chars aren't changed.
+			if string(w.boxCorners) != "++++" || w.boxEdge != "-" || w.boxSide != "|" {
+				t.Errorf("%d: the box chars were changed: %q %q %q", i, string(w.boxCorners), w.boxEdge, w.boxSide)
+			}
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("%d: got no error want %q", i, test.err)
+		}
+	}
+}
+
+func TestFits(t *testing.T) {
+	tests := []struct {
+		value     string
+		style     CommentStyle
+		fillExact bool
+		expected  bool
+	}{
+		{"", NoComment, false, true},
+		{"just under", NoComment, false, true},   // 10 chars
+		{"at  length!", NoComment, false, false}, // 11 chars
+		{"at  length!", NoComment, true, true},
+		{"just over it", NoComment, true, false}, // 12 chars
+		{"under", CPPComment, false, true},       // 8 chars with the prefix
+		{"at lengt", CPPComment, true, true},     // 11 chars with the prefix
+		{"at length", CPPComment, true, false},   // 12 chars with the prefix
+		{"two\nlines", NoComment, false, false},
+		{"世界", NoComment, false, true},
+	}
+	w := New()
+	w.Length = 11
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		w.FillExact = test.fillExact
+		if w.Fits(test.value) != test.expected {
+			t.Errorf("%d: %q: got %t want %t", i, test.value, !test.expected, test.expected)
+		}
+		// it should agree with the wrapped output
+		if test.value == "" {
+			continue
+		}
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if lines := strings.Count(s, "\n") + 1; test.expected && lines != 1 {
+			t.Errorf("%d: %q fits but was wrapped: %q", i, test.value, s)
+		}
+	}
+}
+
+func TestBlankInput(t *testing.T) {
+	styles := []CommentStyle{NoComment, CPPComment, ShellComment, CComment, SQLComment, LispComment}
+	for _, style := range styles {
+		for _, value := range []string{"   ", "\n\n", "\t", " \r\n\t\n "} {
+			w := New()
+			w.CommentStyle = style
+			s, err := w.String(value)
+			if err != nil {
+				t.Errorf("%s: %q: unexpected error: %q", style, value, err)
+				continue
+			}
+			if s != "" {
+				t.Errorf("%s: %q: got %q want \"\"", style, value, s)
+			}
+			b, err := w.Bytes([]byte(value))
+			if err != nil {
+				t.Errorf("%s: %q: unexpected error: %q", style, value, err)
+				continue
+			}
+			if len(b) != 0 {
+				t.Errorf("%s: %q: got %q want \"\"", style, value, b)
+			}
+		}
+	}
+}
+
+func TestWrapParagraphs(t *testing.T) {
+	ps := strings.Split(gpl20, "\n\n")
+	// blank paragraphs and surrounding whitespace are elided
+	ps = append([]string{"", ps[0]}, "  "+ps[1]+"\n", "\n", ps[2])
+	for _, style := range []CommentStyle{CPPComment, CComment, NoComment} {
+		w := New()
+		w.CommentStyle = style
+		expected, err := w.String(gpl20)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %q", style, err)
+			continue
+		}
+		w.Reset()
+		s, err := w.WrapParagraphs(ps)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %q", style, err)
+			continue
+		}
+		if s != expected {
+			t.Errorf("%s: got %q want %q", style, s, expected)
+		}
+	}
+}
+
+func TestElidedSpaces(t *testing.T) {
+	tests := []struct {
+		value        string
+		leadingSpace LeadingSpace
+		trim         bool
+		expected     string
+		elided       int
+	}{
+		{"aaa bbb ccc ddd eee", ElideLeadingSpace, false, "aaa bbb ccc\nddd eee", 1},
+		{"one two   \nthree four five six   \n  seven", ElideLeadingSpace, false, "one two\nthree four\nfive six\nseven", 9},
+		{"one two   \nthree four five six   \n  seven", KeepLeadingSpace, false, "one two\nthree four\nfive six\n  seven", 7},
+		{"one\u00a0\u00a0\ntwo", ElideLeadingSpace, false, "one\u00a0\u00a0\ntwo", 0},
+		{"one\u00a0\u00a0\ntwo", ElideLeadingSpace, true, "one\ntwo", 2},
+	}
+	w := New()
+	w.Length = 14
+	for i, test := range tests {
+		w.Reset()
+		w.LeadingSpace = test.leadingSpace
+		w.TrimTrailing = test.trim
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		if w.ElidedSpaces() != test.elided {
+			t.Errorf("%d: elided: got %d want %d", i, w.ElidedSpaces(), test.elided)
+		}
+	}
+}
+
+func TestDelimited(t *testing.T) {
+	tests := []struct {
+		style     CommentStyle
+		value     string
+		strict    bool
+		delimited bool
+	}{
+		{CComment, "", false, false},
+		{CComment, " \t", false, false},
+		{CComment, "\n\n", false, false},
+		{CComment, "x", false, true},
+		{CComment, "\n\nx\n\n", false, true},
+		{CComment, "x\n", false, true},
+		{CComment, "an unbreakable_token_that_is_too_long", true, false},
+		{CPPComment, "x", false, false},
+		{NoComment, "x", false, false},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		w.StrictWidth = test.strict
+		s, err := w.String(test.value)
+		if err != nil && !test.strict {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if w.Delimited() != test.delimited {
+			t.Errorf("%d: %q: got %t want %t", i, test.value, w.Delimited(), test.delimited)
+		}
+		begin := strings.Count(s, string(cCommentBegin))
+		end := strings.Count(s, string(cCommentEnd))
+		if begin != end {
+			t.Errorf("%d: %q: %d comment begins but %d comment ends: %q", i, test.value, begin, end, s)
+		}
+		if test.delimited && (!strings.HasPrefix(s, string(cCommentBegin)) || !strings.HasSuffix(s, string(cCommentEnd))) {
+			t.Errorf("%d: %q: expected the output to be delimited: %q", i, test.value, s)
+		}
+	}
+}
+
+func TestMaxBlankLines(t *testing.T) {
+	s := "one\n\n\n\n\ntwo\n \t\n\nthree"
+	tests := []struct {
+		style    CommentStyle
+		max      int
+		expected string
+	}{
+		{NoComment, 0, "one\n\n\n\n\ntwo\n\n\nthree"},
+		{NoComment, 1, "one\n\ntwo\n\nthree"},
+		{NoComment, 2, "one\n\n\ntwo\n\n\nthree"},
+		{CPPComment, 1, "// one\n//\n// two\n//\n// three"},
+		{CComment, 1, "/*\none\n\ntwo\n\nthree\n*/\n"},
+	}
+	for i, test := range tests {
+		w := New()
+		w.CommentStyle = test.style
+		w.MaxBlankLines = test.max
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestPrependHeader(t *testing.T) {
+	header := "Copyright (C) yyyy name of author. This program is free software; you can redistribute it and/or modify it."
+	tests := []struct {
+		style    CommentStyle
+		content  string
+		expected string
+	}{
+		{ShellComment, "#!/bin/sh\necho hello\n", "#!/bin/sh\n# Copyright (C) yyyy name of author. This program is\n# free software; you can redistribute it and/or\n# modify it.\n\necho hello\n"},
+		{ShellComment, "#!/bin/sh", "#!/bin/sh\n# Copyright (C) yyyy name of author. This program is\n# free software; you can redistribute it and/or\n# modify it.\n"},
+		{CComment, "#include <stdio.h>\n", "/*\nCopyright (C) yyyy name of author. This program is\nfree software; you can redistribute it and/or modify\nit.\n*/\n\n#include <stdio.h>\n"},
+		{CPPComment, "", "// Copyright (C) yyyy name of author. This program is\n// free software; you can redistribute it and/or\n// modify it.\n"},
+	}
+	w := New()
+	w.Length = 54
+	for i, test := range tests {
+		w.CommentStyle = test.style
+		s, err := w.PrependHeader(test.content, header)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestIndent(t *testing.T) {
+	tests := []struct {
+		text    string
+		tabSize int
+		width   int
+	}{
+		{"", 8, 0},
+		{"  ", 8, 2},
+		{"\t", 8, 8},
+		{"\t", 4, 4},
+		{"\t> ", 4, 6},
+		{"\u00a0\t\t", 2, 5},
+	}
+	w := New()
+	for i, test := range tests {
+		w.TabSize(test.tabSize)
+		w.IndentText(test.text)
+		text, width := w.Indent()
+		if text != test.text {
+			t.Errorf("%d: text: got %q want %q", i, text, test.text)
+		}
+		if width != test.width {
+			t.Errorf("%d: width: got %d want %d", i, width, test.width)
+		}
+		if width != w.lexOptions().measure(test.text) {
+			t.Errorf("%d: width: got %d, measured %d", i, width, w.lexOptions().measure(test.text))
+		}
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	tests := []struct {
+		columns string
+		tabSize string
+		length  int
+		tab     int
+	}{
+		{"120", "4", 120, 4},
+		{" 100\n", "", 100, TabSize},
+		{"", "2", LineLength, 2},
+		// invalid values are ignored
+		{"", "", LineLength, TabSize},
+		{"wide", "four", LineLength, TabSize},
+		{"0", "-4", LineLength, TabSize},
+		{"80.5", "4x", LineLength, TabSize},
+	}
+	for i, test := range tests {
+		t.Setenv("COLUMNS", test.columns)
+		t.Setenv("LINEWRAP_TABSIZE", test.tabSize)
+		w := NewFromEnv()
+		if w.Length != test.length {
+			t.Errorf("%d: Length: got %d want %d", i, w.Length, test.length)
+		}
+		if w.tabSize != test.tab {
+			t.Errorf("%d: tab size: got %d want %d", i, w.tabSize, test.tab)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	s := "Reality is frequently inaccurate. One is never alone with a rubber duck."
+	w := New()
+	w.Length = 30
+	w.CommentStyle = CComment
+	w.IndentText("  ")
+	w.BlockLinePrefix("* ")
+	w.AlignUnder("Note: ")
+	expected, err := w.String(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+
+	c := w.Clone()
+	c.Length = 20
+	c.CommentStyle = CPPComment
+	c.indentText[0] = '\t' // the copy's configuration doesn't share memory with w's
+	c.label[0] = 'n'
+	c.BlockLinePrefix(" * ")
+	c.TabSize(4)
+	if _, err := c.String(s); err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+
+	if w.Length != 30 {
+		t.Errorf("Length: got %d want 30", w.Length)
+	}
+	if w.CommentStyle != CComment {
+		t.Errorf("CommentStyle: got %s want %s", w.CommentStyle, CComment)
+	}
+	if w.tabSize != TabSize {
+		t.Errorf("tabSize: got %d want %d", w.tabSize, TabSize)
+	}
+	if string(w.indentText) != "      " || w.indentLen != 6 {
+		t.Errorf("indent: got %q, %d want %q, 6", w.indentText, w.indentLen, "      ")
+	}
+	if string(w.label) != "Note: " {
+		t.Errorf("label: got %q want %q", w.label, "Note: ")
+	}
+	if string(w.blockPrefix) != "* " {
+		t.Errorf("blockPrefix: got %q want %q", w.blockPrefix, "* ")
+	}
+	w.Reset()
+	got, err := w.String(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if got != expected {
+		t.Errorf("got %q want %q", got, expected)
+	}
+}
+
+func TestRespectMarkdownBreaks(t *testing.T) {
+	s := "Roses are red,   \nviolets are blue\\\nthis line\n  is reflowed with the next line and \nthis one.\n\nA new paragraph\nstarts here.\n"
+	tests := []struct {
+		style    CommentStyle
+		expected string
+	}{
+		{NoComment, "Roses are red,  \nviolets are blue\\\nthis line is reflowed with\nthe next line and this one.\n\nA new paragraph starts here.\n"},
+		{CPPComment, "// Roses are red,  \n// violets are blue\\\n// this line is reflowed with\n// the next line and this\n// one.\n//\n// A new paragraph starts\n// here.\n//"},
+	}
+	w := New()
+	w.Length = 30
+	w.RespectMarkdownBreaks = true
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestWrapIfNeeded(t *testing.T) {
+	tests := []struct {
+		value     string
+		style     CommentStyle
+		needsWrap bool
+		expected  string
+	}{
+		{"Reality is inaccurate.", NoComment, false, "Reality is inaccurate."},
+		{"Reality is frequently\ninaccurate.\n\nOne is never alone.\n", NoComment, false, "Reality is frequently\ninaccurate.\n\nOne is never alone.\n"},
+		{"  indented first line", NoComment, false, "  indented first line"},
+		{"Reality is frequently inaccurate. One is never alone.", NoComment, true, "Reality is frequently\ninaccurate. One is never\nalone."},
+		{"Reality is\n  frequently inaccurate.", NoComment, true, "Reality is\nfrequently inaccurate."},
+		{"Reality is  \nfrequently inaccurate.", NoComment, true, "Reality is\nfrequently inaccurate."},
+		{"Reality is\r\nfrequently inaccurate.", NoComment, true, "Reality is\nfrequently inaccurate."},
+		{"Reality is frequently inaccurate.", CPPComment, true, "// Reality is frequently\n// inaccurate."},
+	}
+	w := New()
+	w.Length = 26
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		if w.NeedsWrap(test.value) != test.needsWrap {
+			t.Errorf("%d: NeedsWrap: got %t want %t", i, !test.needsWrap, test.needsWrap)
+		}
+		s, err := w.WrapIfNeeded(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		if test.needsWrap {
+			continue
+		}
+		// the input is returned as is; wrapping it wouldn't change it
+		w.Reset()
+		wrapped, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if wrapped != test.value {
+			t.Errorf("%d: wrapping changed %q to %q", i, test.value, wrapped)
+		}
+	}
+	// configurations that change text that fits.
+	configs := []struct {
+		value    string
+		config   func(w *Wrapper)
+		expected string
+	}{
+		{"a\nb", func(w *Wrapper) { w.IndentText("  ") }, "a\n  b"},
+		{"    a\nb", func(w *Wrapper) { w.AutoIndent = true }, "    a\n    b"},
+		{"a", func(w *Wrapper) { w.PadToWidth = true; w.Length = 4 }, "a   "},
+		{"a\nb", func(w *Wrapper) { w.Newline("\r\n") }, "a\r\nb"},
+		{"a\u200Bb", func(w *Wrapper) { w.StripZeroWidthSpace = true }, "ab"},
+		{"mind\u00ADboggling", func(w *Wrapper) { w.SoftHyphenOnly = true }, "mindboggling"},
+		{"Quoi?", func(w *Wrapper) { w.Locale("fr") }, "Quoi\u202F?"},
+		{"> a\n> b", func(w *Wrapper) { w.EmailQuotes = true }, "> a b"},
+	}
+	for i, test := range configs {
+		w := New()
+		test.config(w)
+		if !w.NeedsWrap(test.value) {
+			t.Errorf("config %d: NeedsWrap: got false want true", i)
+		}
+		s, err := w.WrapIfNeeded(test.value)
+		if err != nil {
+			t.Errorf("config %d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("config %d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestDetectTables(t *testing.T) {
+	s := "The results are in the table below; they are rounded.\n\n| name | value |\n|------|-------|\n| a very long name for a row | 1 |\n| b | 2 |\n\nName        Value    Notes\nalpha       1        first of the values\nbeta        22       second\n\nThe table above is not wrapped, but this paragraph is."
+	tests := []struct {
+		markdown bool
+		expected string
+	}{
+		{false, "// The results are in the\n// table below; they are\n// rounded.\n//\n// | name | value |\n// |------|-------|\n// | a very long name for a row | 1 |\n// | b | 2 |\n//\n// Name        Value    Notes\n// alpha       1        first of the values\n// beta        22       second\n//\n// The table above is not\n// wrapped, but this\n// paragraph is."},
+		// the new lines of the rows are kept
+		{true, "// The results are in the\n// table below; they are\n// rounded.\n//\n// | name | value |\n// |------|-------|\n// | a very long name for a row | 1 |\n// | b | 2 |\n//\n// Name        Value    Notes\n// alpha       1        first of the values\n// beta        22       second\n//\n// The table above is not\n// wrapped, but this\n// paragraph is."},
+	}
+	w := New()
+	w.Length = 30
+	w.CommentStyle = CPPComment
+	w.DetectTables = true
+	for i, test := range tests {
+		w.Reset()
+		w.RespectMarkdownBreaks = test.markdown
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestExpandCommentTabs(t *testing.T) {
+	tests := []struct {
+		value    string
+		style    CommentStyle
+		expand   bool
+		expected string
+	}{
+		{"key:\tvalue\tand more text\twith tabs in it.", ShellComment, false, "# key:\tvalue\tand\n# more text\twith\n# tabs in it."},
+		{"key:\tvalue\tand more text\twith tabs in it.", ShellComment, true, "# key:    value    and\n# more text    with\n# tabs in it."},
+		{"\tindented\n\t\ttwice", ShellComment, false, "# \tindented\n# \t\ttwice"},
+		{"\tindented\n\t\ttwice", ShellComment, true, "#     indented\n#         twice"},
+		// only comments are expanded
+		{"\tindented\n\t\ttwice", NoComment, true, "\tindented\n\t\ttwice"},
+	}
+	w := New()
+	w.Length = 24
+	w.LeadingSpace = KeepLeadingSpace
+	w.TabSize(4)
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		w.ExpandCommentTabs = test.expand
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestVerbatim(t *testing.T) {
+	quoted := func(line string) bool { return strings.HasPrefix(line, ">") }
+	tests := []struct {
+		value    string
+		verbatim func(string) bool
+		expected string
+	}{
+		{
+			"Some text that will be wrapped to fit.\n> quoted   text that is long and kept as is.  \n>    indented\nMore text that will be wrapped to fit.",
+			nil,
+			"Some text that will\nbe wrapped to fit.\n> quoted   text\nthat is long and\nkept as is.\n>    indented\nMore text that will\nbe wrapped to fit.",
+		},
+		{
+			"Some text that will be wrapped to fit.\n> quoted   text that is long and kept as is.  \n>    indented\nMore text that will be wrapped to fit.",
+			quoted,
+			"Some text that will\nbe wrapped to fit.\n> quoted   text that is long and kept as is.  \n>    indented\nMore text that will\nbe wrapped to fit.",
+		},
+		{"\r\n> a quote that is kept as is\r\n", quoted, "\n> a quote that is kept as is\n"},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.Verbatim = test.verbatim
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestCommentSeparator(t *testing.T) {
+	s := "Reality is frequently inaccurate.\n\nOne is never alone with a rubber duck."
+	tests := []struct {
+		sep      string
+		style    CommentStyle
+		expected string
+	}{
+		{" ", CPPComment, "// Reality is\n// frequently\n// inaccurate.\n//\n// One is never alone\n// with a rubber duck."},
+		{"", CPPComment, "//Reality is frequently\n//inaccurate.\n//\n//One is never alone\n//with a rubber duck."},
+		{" * ", CPPComment, "// * Reality is\n// * frequently\n// * inaccurate.\n// *\n// * One is never alone\n// * with a rubber\n// * duck."},
+		{"", ShellComment, "#Reality is frequently\n#inaccurate.\n#\n#One is never alone\n#with a rubber duck."},
+	}
+	for i, test := range tests {
+		w := New()
+		w.Length = 24
+		w.CommentStyle = test.style
+		w.CommentSeparator(test.sep)
+		c, err := w.String(s)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestParagraphSeparator(t *testing.T) {
+	ps := []string{"Reality is frequently inaccurate.", "One is never alone with a rubber duck.", "Space is big."}
+	tests := []struct {
+		sep      string
+		expected string
+	}{
+		{"", "# Reality is frequently\n# inaccurate.\n#\n# One is never alone\n# with a rubber duck.\n#\n# Space is big."},
+		{"~~~", "# Reality is frequently\n# inaccurate.\n# ~~~\n# One is never alone\n# with a rubber duck.\n# ~~~\n# Space is big."},
+		{"\n", "# Reality is frequently\n# inaccurate.\n#\n#\n# One is never alone\n# with a rubber duck.\n#\n#\n# Space is big."},
+	}
+	w := New()
+	w.Length = 24
+	w.CommentStyle = ShellComment
+	for i, test := range tests {
+		w.Reset()
+		w.ParagraphSeparator(test.sep)
+		c, err := w.WrapParagraphs(ps)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if c != test.expected {
+			t.Errorf("%d: got %q want %q", i, c, test.expected)
+		}
+	}
+}
+
+func TestContinuationChar(t *testing.T) {
+	value := "see https://example.com/a/very/long/path for more"
+	tests := []struct {
+		breakLong bool
+		char      rune
+		expected  string
+	}{
+		{false, '\\', "see\nhttps://example.com/a/very/long/path\nfor more"},
+		{true, 0, "see\nhttps://exa\nmple.com/a/\nvery/long/p\nath for\nmore"},
+		{true, '\\', "see\nhttps://ex\\\nample.com/\\\na/very/lon\\\ng/path for\nmore"},
+	}
+	w := New()
+	w.Length = 12
+	for i, test := range tests {
+		w.Reset()
+		w.BreakLongWords = test.breakLong
+		w.ContinuationChar = test.char
+		s, err := w.String(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestPadToWidth(t *testing.T) {
+	value := "The quick brown fox jumped over the lazy dog."
+	tests := []struct {
+		style    CommentStyle
+		expected string
+	}{
+		{NoComment, "The quick brown fox \njumped over the     \nlazy dog.           "},
+		{CPPComment, "// The quick brown  \n// fox jumped over  \n// the lazy dog.    "},
+		// the comment delimiters aren't padded.
+		{CComment, "/*\nThe quick brown fox \njumped over the     \nlazy dog.           \n*/\n"},
+	}
+	w := New()
+	w.Length = 20
+	w.PadToWidth = true
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		s, err := w.String(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		for j, line := range strings.Split(s, "\n") {
+			if line == "/*" || line == "*/" || line == "" {
+				continue
+			}
+			if len(line) != w.Length {
+				t.Errorf("%d: line %d: got %d chars want %d", i, j, len(line), w.Length)
+			}
+		}
+	}
+	// the padding goes before the suffix
+	w.Reset()
+	w.CommentStyle = NoComment
+	s, err := w.WrapBetween("key = ", "value", " # note")
+	if err != nil {
+		t.Errorf("WrapBetween: unexpected error: %q", err)
+	}
+	if want := "key = value   # note"; s != want {
+		t.Errorf("WrapBetween: got %q want %q", s, want)
+	}
+}
+
+func TestFillChar(t *testing.T) {
+	tests := []struct {
+		fill     rune
+		prefix   string
+		body     string
+		suffix   string
+		expected string
+	}{
+		{'.', "Chapter 1 ", "Introduction", " 5", "Chapter 1 Introduction.... 5"},
+		{'.', "Chapter 2 ", "Wrapping long lines of text", " 17", "Chapter 2 Wrapping long.....\n          lines of text.. 17"},
+		{'-', "", "fill", "", "fill------------------------"},
+		// the fill char is 2 chars wide; the odd char is a space
+		{'=', "", "fills", "", "fills=========== "},
+	}
+	w := New()
+	w.Length = 28
+	w.PadToWidth = true
+	w.RuneWidth(func(r rune) int {
+		if r == '=' {
+			return 2
+		}
+		return 1
+	})
+	for i, test := range tests {
+		w.Reset()
+		w.FillChar(test.fill)
+		s, err := w.WrapBetween(test.prefix, test.body, test.suffix)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestBlankLineIndent(t *testing.T) {
+	value := "one two three four five\n\nsix seven\n\n\neight\n"
+	expected := "one two three four\n  \t five\n\n  \t six\n  \t seven\n\n\n  \t eight\n"
+	w := New()
+	w.Length = 20
+	w.IndentText("  \t ")
+	for i, trim := range []bool{true, false} {
+		w.Reset()
+		w.TrimTrailing = trim
+		s, err := w.String(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != expected {
+			t.Errorf("%d: got %q want %q", i, s, expected)
+		}
+		for j, line := range strings.Split(s, "\n") {
+			if strings.TrimSpace(line) == "" && line != "" {
+				t.Errorf("%d: line %d: got %q want an empty line", i, j, line)
+			}
+		}
+	}
+}
+
+func TestWrapBoth(t *testing.T) {
+	value := "one two three four five\n\nsix seven eight nine ten eleven"
+	tests := []struct {
+		style     CommentStyle
+		plain     string
+		commented string
+	}{
+		{NoComment, "one two three four five\n\nsix seven eight nine\nten eleven", "one two three four five\n\nsix seven eight nine\nten eleven"},
+		{CPPComment, "one two three four\nfive\n\nsix seven eight nine\nten eleven", "// one two three four\n// five\n//\n// six seven eight nine\n// ten eleven"},
+		{CComment, "one two three four five\n\nsix seven eight nine\nten eleven", "/*\none two three four five\n\nsix seven eight nine\nten eleven\n*/\n"},
+	}
+	w := New()
+	w.Length = 24
+	for i, test := range tests {
+		w.CommentStyle = test.style
+		plain, commented, err := w.WrapBoth(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if plain != test.plain {
+			t.Errorf("%d: plain: got %q want %q", i, plain, test.plain)
+		}
+		if commented != test.commented {
+			t.Errorf("%d: commented: got %q want %q", i, commented, test.commented)
+		}
+		// the lines of the comment, without its delimiters, are broken where
+		// the plain lines are.
+		lines := strings.Split(strings.TrimSuffix(commented, "\n"), "\n")
+		if test.style == CComment {
+			lines = lines[1 : len(lines)-1]
+		}
+		if got, want := len(lines), strings.Count(plain, "\n")+1; got != want {
+			t.Errorf("%d: got %d commented lines want %d", i, got, want)
+		}
+	}
+}
+
+func TestBreakDecider(t *testing.T) {
+	value := "one two three four five six seven eight"
+	expected := "one two three\nfour five six\nseven eight"
+	w := New()
+	for i, length := range []int{80, 10} {
+		var words int
+		w.Reset()
+		w.Length = length
+		// break before every third word, regardless of the width.
+		w.BreakDecider(func(ctx BreakContext) bool {
+			if ctx.Kind != TextToken {
+				return false
+			}
+			words++
+			return words%3 == 1
+		})
+		s, err := w.String(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != expected {
+			t.Errorf("%d: got %q want %q", i, s, expected)
+		}
+	}
+}
+
+func TestFormatChars(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		// the line isn't broken at the zero width non-joiner, and it doesn't
+		// count against Length
+		{"ab mi\u200Cxaham", "ab mi\u200Cxaham"},
+		{"abc mi\u200Cxaham", "abc\nmi\u200Cxaham"},
+		// nor is it broken at the zero width joiner
+		{"ab m\u200Dw\u200Dw wxyz", "ab m\u200Dw\u200Dw\nwxyz"},
+		{"abcdefgh\u200Dxyz", "abcdefgh\u200Dxyz"},
+	}
+	w := New()
+	w.Length = 11
+	for i, test := range tests {
+		w.Reset()
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestWrapMaxBytes(t *testing.T) {
+	value := "The quick brown fox jumped over the lazy dog. It was a nice dog."
+	tests := []struct {
+		max       int
+		newline   string
+		expected  string
+		truncated bool
+	}{
+		{100, "", "The quick brown fox\njumped over the lazy\ndog. It was a nice\ndog.", false},
+		{64, "", "The quick brown fox\njumped over the lazy\ndog. It was a nice\ndog.", false},
+		// the paragraph is cut at the end of the last line that fits
+		{63, "", "The quick brown fox\njumped over the lazy\ndog. It was a nice", true},
+		{40, "", "The quick brown fox\njumped over the lazy", true},
+		{39, "", "The quick brown fox", true},
+		{40, "\r\n", "The quick brown fox", true},
+		{10, "", "", true},
+	}
+	w := New()
+	w.Length = 21
+	for i, test := range tests {
+		w.Newline(test.newline)
+		s, truncated, err := w.WrapMaxBytes(value, test.max)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		if truncated != test.truncated {
+			t.Errorf("%d: truncated: got %t want %t", i, truncated, test.truncated)
+		}
+		if len(s) > test.max {
+			t.Errorf("%d: got %d bytes want at most %d", i, len(s), test.max)
+		}
+	}
+	// a CComment always ends with the comment end.
+	comments := []struct {
+		max      int
+		expected string
+		err      string
+	}{
+		{100, "/*\nThe quick brown fox\njumped over the lazy\ndog. It was a nice\ndog.\n*/\n", ""},
+		{50, "/*\nThe quick brown fox\njumped over the lazy\n*/\n", ""},
+		{10, "/*\n*/\n", ""},
+		{5, "", "max 5 bytes can't hold the comment delimiters: 6 bytes are needed"},
+	}
+	w.Newline("")
+	w.CommentStyle = CComment
+	for i, test := range comments {
+		s, _, err := w.WrapMaxBytes(value, test.max)
+		if err != nil {
+			if err.Error() != test.err {
+				t.Errorf("comment %d: got error %q want %q", i, err, test.err)
+			}
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("comment %d: got no error want %q", i, test.err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("comment %d: got %q want %q", i, s, test.expected)
+		}
+		if len(s) > test.max {
+			t.Errorf("comment %d: got %d bytes want at most %d", i, len(s), test.max)
+		}
+	}
+}
+
+func TestReflowComment(t *testing.T) {
+	value := "// one two\nREM three four\n  rem five\n#six seven\nRem\n-- eight nine ten eleven\nremember me"
+	tests := []struct {
+		style    CommentStyle
+		expected string
+	}{
+		{CPPComment, "// one two three\n// four five six\n// seven\n//\n// eight nine ten\n// eleven remember\n// me"},
+		{ShellComment, "# one two three four\n# five six seven\n#\n# eight nine ten\n# eleven remember me"},
+		{NoComment, "one two three four\nfive six seven\n\neight nine ten\neleven remember me"},
+	}
+	w := New()
+	w.Length = 21
+	for i, test := range tests {
+		w.CommentStyle = test.style
+		s, err := w.ReflowComment(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	value := "Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.\n\nI mean, you may think it's a long way down the road to the chemist's, but that's just peanuts to space."
+	w := New()
+	w.Length = 30
+	w.CommentStyle = CPPComment
+	s, err := w.String(value)
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	expected := strings.Split(s, "\n")
+	w.Reset()
+	r := w.LineReader(strings.NewReader(value))
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %q", err)
+		}
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("got %d lines want %d", len(lines), len(expected))
+	}
+	for i := range lines {
+		if lines[i] != expected[i] {
+			t.Errorf("%d: got %q want %q", i, lines[i], expected[i])
+		}
+	}
+	// an error reading the source is returned by the reader
+	r = w.LineReader(io.MultiReader(strings.NewReader("text"), iotest.ErrReader(errors.New("read error"))))
+	if _, err := r.ReadString('\n'); err == nil || err.Error() != "read error" {
+		t.Errorf("got %v want read error", err)
+	}
+	// a reader that isn't read to the end doesn't leak a go routine
+	long := strings.Repeat(value+"\n", 100)
+	n := leaks(10, func() {
+		w.LineReader(strings.NewReader(long)).ReadString('\n')
+	})
+	if n > 0 {
+		t.Errorf("%d go routines were leaked", n)
+	}
+}
+
+func TestPreserveCodeBlocks(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		// indented prose within a paragraph isn't a code block
+		{"Some prose that is long enough to wrap\n    and indented prose that continues the paragraph.", "Some prose that is long enough\nto wrap\nand indented prose that\ncontinues the paragraph."},
+		// an indented line after a blank line starts one
+		{"Some prose that is long enough to wrap.\n\n    for i := 0; i < 10; i++ { fmt.Println(i) }\n\n    x := 1\n\nMore prose that wraps around the end.", "Some prose that is long enough\nto wrap.\n\n    for i := 0; i < 10; i++ { fmt.Println(i) }\n\n    x := 1\n\nMore prose that wraps around\nthe end."},
+		{"\tif err != nil { return err }\nProse that isn't indented ends the code block.", "\tif err != nil { return err }\nProse that isn't indented ends\nthe code block."},
+	}
+	w := New()
+	w.Length = 31
+	w.PreserveCodeBlocks = true
+	for i, test := range tests {
+		w.Reset()
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestTrailingCommentLine(t *testing.T) {
+	tests := []struct {
+		value    string
+		style    CommentStyle
+		trailing bool
+		expected string
+	}{
+		{"Space is big. You just won't believe it.", CPPComment, false, "// Space is big. You just\n// won't believe it."},
+		{"Space is big. You just won't believe it.", CPPComment, true, "// Space is big. You just\n// won't believe it.\n//"},
+		{"Space is big. You just won't believe it.", ShellComment, true, "# Space is big. You just\n# won't believe it.\n#"},
+		// the text's blank comment line is the trailing line
+		{"Space is big. You just won't believe it.\n", CPPComment, true, "// Space is big. You just\n// won't believe it.\n//"},
+		// the trailing whitespace is only elided once
+		{"Space is big. It is.   ", CPPComment, true, "// Space is big. It is.\n//"},
+		{"Space is big.\n\nIt is. \t", CPPComment, true, "// Space is big.\n//\n// It is.\n//"},
+		{"Space is big.", NoComment, true, "Space is big."},
+	}
+	w := New()
+	w.Length = 27
+	for i, test := range tests {
+		w.Reset()
+		w.CommentStyle = test.style
+		w.TrailingCommentLine = test.trailing
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestProtectPlaceholders(t *testing.T) {
+	tests := []struct {
+		value    string
+		protect  bool
+		expected string
+	}{
+		{"Total: %-10.2f due {{.LongName}}", false, "Total: %-\n10.2f due\n{{.LongName}}"},
+		{"Total: %-10.2f due {{.LongName}}", true, "Total:\n%-10.2f due\n{{.LongName}}"},
+		{"Dear {{ .Name | printf \"%s\" }}, hi", false, "Dear {{ .Name\n| printf \"%s\"\n}}, hi"},
+		{"Dear {{ .Name | printf \"%s\" }}, hi", true, "Dear\n{{ .Name | printf \"%s\" }},\nhi"},
+		{"%[1]*d items are 50% off-price", true, "%[1]*d items\nare 50% off-\nprice"},
+	}
+	w := New()
+	w.Length = 14
+	for i, test := range tests {
+		w.Reset()
+		w.ProtectPlaceholders = test.protect
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestDashBreaks(t *testing.T) {
+	value := "Hayastan abcdefgh\u058Aklmno x-y"
+	tests := []struct {
+		breaks   map[rune]BreakDirection
+		expected string
+	}{
+		{nil, "Hayastan\nabcdefgh\u058A\nklmno x-y"},
+		{map[rune]BreakDirection{'\u058A': DashBreakAfter}, "Hayastan\nabcdefgh\u058A\nklmno x-y"},
+		{map[rune]BreakDirection{'\u058A': DashBreakBefore}, "Hayastan\nabcdefgh\n\u058Aklmno x-\ny"},
+		{map[rune]BreakDirection{'\u058A': DashNoBreak}, "Hayastan\nabcdefgh\u058Aklmno\nx-y"},
+		{map[rune]BreakDirection{'-': DashBreakBefore}, "Hayastan\nabcdefgh\u058A\nklmno x-y"},
+	}
+	w := New()
+	w.Length = 10
+	for i, test := range tests {
+		w.Reset()
+		w.DashBreaks = test.breaks
+		s, err := w.String(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestTabStops(t *testing.T) {
+	value := "abcde\tfg\thij\tk"
+	tests := []struct {
+		stops    bool
+		style    CommentStyle
+		expand   bool
+		expected string
+	}{
+		{false, NoComment, false, "abcde\tfg\nhij\tk"},
+		// the tabs advance to columns 8 and 16; the tabs are kept
+		{true, NoComment, false, "abcde\tfg\thij\nk"},
+		{true, ShellComment, false, "# abcde\tfg\thij\n# k"},
+		// expanded, a tab is the spaces to the next tab stop
+		{true, ShellComment, true, "# abcde fg      hij\n# k"},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.TabStops = test.stops
+		w.CommentStyle = test.style
+		w.ExpandCommentTabs = test.expand
+		s, err := w.String(value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestOutputSize(t *testing.T) {
+	values := []string{
+		"",
+		"Space is big.",
+		"Space is big. You just won't believe how vastly, hugely, mind-bogglingly big it is.\n\nI mean, you may think it's a long way down the road to the chemist's, but that's just peanuts to space.",
+		"Хорошо.\tОчень хорошо. 못 알아 듣겠어요, but that's just peanuts to space.",
+		"> Time is an illusion. Lunchtime doubly so.\n> > Very deep. You should send that in to the Reader's Digest.\nThey've got a page for people like you.",
+		strings.Repeat("Reality is frequently inaccurate, one is never alone with a rubber duck. ", 50),
+	}
+	configs := []func(w *Wrapper){
+		func(w *Wrapper) {},
+		func(w *Wrapper) { w.CommentStyle = CPPComment },
+		func(w *Wrapper) { w.CommentStyle = CComment; w.IndentText("\t") },
+		func(w *Wrapper) { w.IndentText("    "); w.Newline("\r\n") },
+		func(w *Wrapper) { w.CommentStyle = ShellComment; w.SoftBreakMarker(" \\"); w.Length = 20 },
+		func(w *Wrapper) { w.MinWordsPerLine = 2; w.Length = 21 },
+		func(w *Wrapper) { w.PadToWidth = true; w.CommentStyle = CComment },
+		func(w *Wrapper) { w.EmailQuotes = true; w.SentencePerLine = true },
+		func(w *Wrapper) { w.ClauseBreaks = true; w.PreferSpaceBreaks = true; w.SoftHyphenOnly = true },
+		func(w *Wrapper) { w.MaxLines = 3 },
+	}
+	for i, config := range configs {
+		for j, value := range values {
+			w := New()
+			w.Length = 30
+			config(w)
+			n, err := w.OutputSize(value)
+			if err != nil {
+				t.Errorf("%d:%d: unexpected error: %q", i, j, err)
+				continue
+			}
+			s, err := w.String(value)
+			if err != nil {
+				t.Errorf("%d:%d: unexpected error: %q", i, j, err)
+				continue
+			}
+			if n != len(s) {
+				t.Errorf("%d:%d: got %d want %d", i, j, n, len(s))
+			}
+		}
+	}
+}
+
+func TestLocale(t *testing.T) {
+	tests := []struct {
+		locale   string
+		value    string
+		expected string
+	}{
+		// no break occurs before the '!'; the space is a narrow no-break space
+		{"", "C'est magnifique !", "C'est magnifique\n!"},
+		{"fr", "C'est magnifique !", "C'est\nmagnifique\u202F!"},
+		{"fr-FR", "C'est magnifique\u00A0!", "C'est\nmagnifique\u202F!"},
+		// the narrow no-break space is inserted if there isn't any space
+		{"fr", "Quoi?! Il est 10:30 ; voir https://example.com", "Quoi\u202F?! Il est\n10:30\u202F; voir\nhttps://example.com"},
+		{"en", "Quoi?! Il est 10:30 ; voir https://example.com", "Quoi?! Il est\n10:30 ; voir\nhttps://example.com"},
+	}
+	w := New()
+	w.Length = 18
+	for i, test := range tests {
+		w.Reset()
+		w.Locale(test.locale)
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []BreakCandidate
+	}{
+		{"", nil},
+		{"word", nil},
+		{
+			"The quick-brown fox\n  jumps  over\tit.",
+			[]BreakCandidate{
+				{3, 3, SpaceToken}, {10, 10, HyphenToken}, {15, 15, SpaceToken}, {19, 19, NewlineToken},
+				{27, 5, SpaceToken}, {33, 11, TabToken},
+			},
+		},
+	}
+	w := New()
+	for i, test := range tests {
+		cs := w.Analyze(test.value)
+		if len(cs) != len(test.expected) {
+			t.Errorf("%d: got %d candidates want %d: %v", i, len(cs), len(test.expected), cs)
+			continue
+		}
+		for j, c := range cs {
+			if c != test.expected[j] {
+				t.Errorf("%d:%d: got %+v want %+v", i, j, c, test.expected[j])
+			}
+		}
+	}
+}
+
+func TestKeepLeadingTabs(t *testing.T) {
+	tests := []struct {
+		leading  LeadingSpace
+		keepTabs bool
+		value    string
+		expected string
+	}{
+		// a continuation's leading tabs are elided like spaces.
+		{ElideLeadingSpace, false, "aaaa\tbbbb cc", "aaaa\nbbbb cc"},
+		{ElideLeadingSpace, false, "aaaa \t bbbb cc", "aaaa\nbbbb cc"},
+		{KeepLeadingSpace, false, "aaaa\t\tbbbb", "aaaa\nbbbb"},
+		{KeepLeadingSpace, false, "aaa\n\tbb cc dd ee", "aaa\n\tbb cc\ndd ee"},
+		// KeepLeadingTabs only applies to KeepLeadingSpace.
+		{ElideLeadingSpace, true, "aaa\n\tbb cc dd ee", "aaa\nbb cc dd\nee"},
+		{KeepLeadingSpace, true, "aaa\n\tbb cc dd ee", "aaa\n\tbb cc\n\tdd ee"},
+		{KeepLeadingSpace, true, "aaaa\t\tbbbb", "aaaa\nbbbb"},
+		{KeepLeadingSpace, true, "\tfunc(a, b,\n\t\tc, d, eee)", "\tfunc(a,\n\tb,\n\t\tc,\n\t\td,\n\t\teee)"},
+		{KeepLeadingSpace, true, "\tbb cc dd\n  \tdd ee ff", "\tbb cc\n\tdd\n  \tdd\nee ff"},
+	}
+	w := New()
+	w.Length = 10
+	w.TabSize(4)
+	for i, test := range tests {
+		w.Reset()
+		w.LeadingSpace = test.leading
+		w.KeepLeadingTabs = test.keepTabs
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	msg := "The meeting has been moved to Thursday at 3pm because the projector in room B-12 is broken again, sorry everyone!"
+	tests := []struct {
+		indicator bool
+		limit     int
+		value     string
+		expected  []string
+	}{
+		{false, 30, "", nil},
+		{true, 30, "short", []string{"short"}},
+		{false, 30, msg, []string{"The meeting has been moved to", "Thursday at 3pm because the", "projector in room B-12 is", "broken again, sorry everyone!"}},
+		{true, 30, msg, []string{"The meeting has been (1/5)", "moved to Thursday at 3pm (2/5)", "because the projector in (3/5)", "room B-12 is broken (4/5)", "again, sorry everyone! (5/5)"}},
+		{false, 10, "a well-known\nword", []string{"a well-", "known word"}},
+		{false, 10, "supercalifragilistic word", []string{"supercalif", "ragilistic", "word"}},
+		{true, 10, "supercalifragilistic", []string{"supe (1/5)", "rcal (2/5)", "ifra (3/5)", "gili (4/5)", "stic (5/5)"}},
+		{true, 6, "supercalifragilistic", nil}, // there's no room for text with the indicator
+	}
+	w := New()
+	w.LengthUnit = Bytes
+	for i, test := range tests {
+		w.SplitIndicator = test.indicator
+		msgs := w.Split(test.value, test.limit)
+		if len(msgs) != len(test.expected) {
+			t.Errorf("%d: got %d messages want %d: %q", i, len(msgs), len(test.expected), msgs)
+			continue
+		}
+		for j, m := range msgs {
+			if m != test.expected[j] {
+				t.Errorf("%d:%d: got %q want %q", i, j, m, test.expected[j])
+			}
+			if len(m) > test.limit {
+				t.Errorf("%d:%d: got %d bytes; limit is %d", i, j, len(m), test.limit)
+			}
+		}
+	}
+}
+
+func TestAutoIndent(t *testing.T) {
+	tests := []struct {
+		indent   string
+		value    string
+		expected string
+	}{
+		{"", "    The quick brown fox jumped over the lazy dog.", "    The quick brown\n    fox jumped over\n    the lazy dog."},
+		{"", "\tThe quick brown fox jumped\nover the lazy dog.", "\tThe quick brown\n\tfox jumped\n\tover the lazy\n\tdog."},
+		{"", "The quick brown fox jumped over the lazy dog.", "The quick brown fox\njumped over the lazy\ndog."},
+		{"  ", "    The quick brown fox jumped over the lazy dog.", "    The quick brown\n    fox jumped over\n    the lazy dog."},
+		{"  ", "The quick brown fox jumped over the lazy dog.", "The quick brown fox\n  jumped over the\n  lazy dog."},
+	}
+	w := New()
+	w.Length = 22
+	w.TabSize(4)
+	w.AutoIndent = true
+	for i, test := range tests {
+		w.Reset()
+		w.IndentText(test.indent)
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+		// the detected indent only applies to the input it was detected in.
+		if text, _ := w.Indent(); text != test.indent {
+			t.Errorf("%d: got indent %q want %q", i, text, test.indent)
+		}
+	}
+}
+
+func TestPreferSpaceBreaks(t *testing.T) {
+	tests := []struct {
+		prefer   bool
+		slack    int
+		value    string
+		expected string
+	}{
+		{false, 0, "It was a long-awaited day for us.", "It was a long-\nawaited day for us."},
+		{true, 0, "It was a long-awaited day for us.", "It was a\nlong-awaited day\nfor us."},
+		{true, 5, "It was a long-awaited day for us.", "It was a\nlong-awaited day\nfor us."},
+		{true, 4, "It was a long-awaited day for us.", "It was a long-\nawaited day for us."},
+		// the dash is still broken after if the word doesn't fit either way.
+		{true, 0, "It was an extraordinarily-long day.", "It was an\nextraordinarily-\nlong day."},
+		// a space after the dash is the break point.
+		{true, 0, "Some self-evident truths- or not", "Some self-evident\ntruths- or not"},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.PreferSpaceBreaks = test.prefer
+		w.SpaceBreakSlack = test.slack
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+}
+
+func TestKeepAbbreviations(t *testing.T) {
+	tests := []struct {
+		keep     bool
+		value    string
+		expected string
+	}{
+		{false, "Use a short name, like e.g. foo, for it.", "Use a short name,\nlike e.g. foo, for\nit."},
+		{true, "Use a short name, like e.g. foo, for it.", "Use a short name,\nlike e.g. foo, for\nit."},
+		{false, "Use a name (e.g. foo) for it.", "Use a name (e.g.\nfoo) for it."},
+		{true, "Use a name (e.g. foo) for it.", "Use a name\n(e.g. foo) for it."},
+		{true, "Cats vs. dogs vs. Mice, a story", "Cats vs. dogs\nvs. Mice, a story"},
+		// the abbreviation and the word don't fit on a line together.
+		{true, "Use a short name, E.g. supercalifragilistic", "Use a short name,\nE.g.\nsupercalifragilistic"},
+	}
+	w := New()
+	w.Length = 20
+	for i, test := range tests {
+		w.Reset()
+		w.KeepAbbreviations = test.keep
+		s, err := w.String(test.value)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %q", i, err)
+			continue
+		}
+		if s != test.expected {
+			t.Errorf("%d: got %q want %q", i, s, test.expected)
+		}
+	}
+	// an abbreviation doesn't end a sentence; see SentencePerLine.
+	w.Reset()
+	w.Length = 40
+	w.KeepAbbreviations = true
+	w.SentencePerLine = true
+	s, err := w.String("Use a tool, e.g. linewrap. Done.")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if expected := "Use a tool, e.g. linewrap.\nDone."; s != expected {
+		t.Errorf("got %q want %q", s, expected)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 Joel Scoble
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy
+// of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package linewrap
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+const readSize = 4096 // the number of bytes a Scanner reads at a time
+
+// Token is a token read by a Scanner.
+type Token struct {
+	Kind  TokenKind // how the token is classified for line breaking
+	Value string    // the text of the token
+	Pos   int       // the byte offset of the token in the input
+}
+
+// Scanner reads the tokens, as classified for line breaking, from an
+// io.Reader, without needing all of the input, e.g. for a layout that is done
+// as the input is streamed. It is modeled on bufio.Scanner: Scan advances to
+// the next token, which is returned by Token, until the end of the input or an
+// error; Err returns the error, if it wasn't io.EOF.
+//
+// A token can span reads, as can the bytes of a rune, so the last token read
+// is held back until either the token that follows it or the end of the
+// input is read.
+type Scanner struct {
+	r      io.Reader
+	read   []byte  // the buffer reads are done into
+	buf    []byte  // the input that hasn't been tokenized yet
+	offset int     // the offset, in the input, of buf
+	tokens []Token // the tokens that haven't been returned by Scan
+	token  Token   // the current token
+	eof    bool    // whether all of the input has been read
+	err    error   // the error from the reader, if it wasn't io.EOF
+	l      *lexer  // reused for each fill
+}
+
+// NewScanner returns a Scanner that reads from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{r: r, read: make([]byte, readSize)}
+}
+
+// Scan advances the Scanner to the next token, which is then available from
+// Token. It returns false when there are no more tokens, either because the
+// end of the input was reached or because of an error; see Err.
+func (s *Scanner) Scan() bool {
+	for len(s.tokens) == 0 {
+		if s.eof {
+			return false
+		}
+		s.fill()
+	}
+	s.token = s.tokens[0]
+	s.tokens = s.tokens[1:]
+	return true
+}
+
+// Token returns the most recent token read by Scan.
+func (s *Scanner) Token() Token {
+	return s.token
+}
+
+// Err returns the first error, other than io.EOF, encountered by the
+// Scanner.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// fill reads more of the input and tokenizes what hasn't been tokenized yet.
+// Unless the end of the input has been reached, the last token is held back,
+// as more of it may be in the next read.
+func (s *Scanner) fill() {
+	n, err := s.r.Read(s.read)
+	held := len(s.buf)
+	s.buf = append(s.buf, s.read[:n]...)
+	if err != nil {
+		s.eof = true
+		if err != io.EOF {
+			s.err = err
+		}
+	}
+	if n == 0 && !s.eof {
+		return
+	}
+	// the held back token is only lexed again once there is a token boundary
+	// in what was read; otherwise a token that spans many reads would be
+	// lexed again on each of them.
+	if !s.eof && held > 0 && !s.split(held) {
+		return
+	}
+	if s.l == nil {
+		s.l = lex(s.buf)
+	} else {
+		s.l.reset(s.buf)
+	}
+	var tokens []Token
+	for {
+		t := s.l.nextToken()
+		if t.typ == tokenEOF || t.typ == tokenError {
+			break
+		}
+		tokens = append(tokens, Token{Kind: t.kind(), Value: t.value, Pos: s.offset + int(t.pos)})
+	}
+	if s.eof {
+		s.tokens = tokens
+		s.buf = nil
+		return
+	}
+	if len(tokens) < 2 { // the only token may not be complete
+		return
+	}
+	last := tokens[len(tokens)-1]
+	s.tokens = tokens[:len(tokens)-1]
+	s.buf = append(s.buf[:0], s.buf[last.Pos-s.offset:]...)
+	s.offset = last.Pos
+}
+
+// split returns whether the bytes in buf from i on, along with the rune that
+// precedes them, are more than one token.
+func (s *Scanner) split(i int) bool {
+	for i--; i > 0 && !utf8.RuneStart(s.buf[i]); i-- {
+	}
+	if s.l == nil {
+		s.l = lex(s.buf[i:])
+	} else {
+		s.l.reset(s.buf[i:])
+	}
+	defer s.l.drain()
+	t := s.l.nextToken()
+	if t.typ == tokenEOF || t.typ == tokenError {
+		return false
+	}
+	t = s.l.nextToken()
+	return t.typ != tokenEOF && t.typ != tokenError
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 Joel Scoble
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy
+// of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package linewrap
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestScanner(t *testing.T) {
+	input := "Hello, 世界　wide \t text—dash\r\nend"
+	expected := []Token{
+		{TextToken, "Hello,", 0},
+		{SpaceToken, " ", 6},
+		{TextToken, "世界", 7},
+		{SpaceToken, "　", 13},
+		{TextToken, "wide", 16},
+		{SpaceToken, " \t ", 20},
+		{TextToken, "text", 23},
+		{HyphenToken, "—", 27},
+		{TextToken, "dash", 30},
+		{NewlineToken, "\n", 35},
+		{TextToken, "end", 36},
+	}
+	readers := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"reader", strings.NewReader(input)},
+		{"one byte reader", iotest.OneByteReader(strings.NewReader(input))},
+		{"data err reader", iotest.DataErrReader(strings.NewReader(input))},
+	}
+	for _, test := range readers {
+		var tokens []Token
+		s := NewScanner(test.r)
+		for s.Scan() {
+			tokens = append(tokens, s.Token())
+		}
+		if err := s.Err(); err != nil {
+			t.Errorf("%s: unexpected error: %q", test.name, err)
+			continue
+		}
+		if len(tokens) != len(expected) {
+			t.Errorf("%s: got %d tokens want %d: %v", test.name, len(tokens), len(expected), tokens)
+			continue
+		}
+		for i, tkn := range tokens {
+			if tkn != expected[i] {
+				t.Errorf("%s: %d: got %v want %v", test.name, i, tkn, expected[i])
+			}
+		}
+	}
+}
+
+func TestScannerErr(t *testing.T) {
+	errTest := errors.New("test error")
+	s := NewScanner(io.MultiReader(strings.NewReader("some text"), iotest.ErrReader(errTest)))
+	var values []string
+	for s.Scan() {
+		values = append(values, s.Token().Value)
+	}
+	if s.Err() != errTest {
+		t.Errorf("got %v want %v", s.Err(), errTest)
+	}
+	if strings.Join(values, "") != "some text" {
+		t.Errorf("got %q want %q", values, []string{"some", " ", "text"})
+	}
+}
+
+func TestScannerLongToken(t *testing.T) {
+	long := strings.Repeat("a", 3*readSize+1)
+	expected := []Token{
+		{TextToken, long, 0},
+		{SpaceToken, " ", len(long)},
+		{TextToken, long, len(long) + 1},
+	}
+	s := NewScanner(strings.NewReader(long + " " + long))
+	var tokens []Token
+	for s.Scan() {
+		tokens = append(tokens, s.Token())
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("got %d tokens want %d", len(tokens), len(expected))
+	}
+	for i, tkn := range tokens {
+		if tkn != expected[i] {
+			t.Errorf("%d: got %v %d want %v %d", i, tkn.Kind, tkn.Pos, expected[i].Kind, expected[i].Pos)
+		}
+	}
+}
+
+func BenchmarkScannerLongToken(b *testing.B) {
+	input := strings.Repeat("a", 4<<20)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := NewScanner(strings.NewReader(input))
+		for s.Scan() {
+		}
+	}
+}